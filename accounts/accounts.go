@@ -0,0 +1,368 @@
+// Package accounts manages the access tokens of several user accounts across several hosts, such as
+// github.com and GitHub Enterprise Server instances, and keeps track of the active account per host.
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/api"
+)
+
+var (
+	// ErrNotFound is returned when there is no matching account.
+	ErrNotFound = errors.New("account not found")
+	// ErrExpired is returned when the token of an account has expired and can not be refreshed, so
+	// that the user has to log in again.
+	ErrExpired = errors.New("token expired")
+)
+
+// Account identifies a user on a host.
+type Account struct {
+	// Host is the host name of the server, e.g. "github.com".
+	Host string
+	// Login is the user name on the host.
+	Login string
+	// ID is the unique and stable identifier of the user on the host, if known.
+	ID string
+	// Active reports whether this is the account in use for the host.
+	Active bool
+}
+
+// Manager keeps track of accounts and their tokens in a Store. It is safe for concurrent use.
+type Manager struct {
+	store    Store
+	identify IdentityFunc
+	now      func() time.Time
+
+	mu sync.Mutex
+	// refreshing holds a lock per account, keyed by host and lowercase login, that serializes the
+	// refreshes of its token.
+	refreshing map[string]*sync.Mutex
+}
+
+// NewManager creates a Manager that persists accounts in store and uses identify to find out which
+// account a new token belongs to.
+func NewManager(store Store, identify IdentityFunc) *Manager {
+	return &Manager{
+		store:    store,
+		identify: identify,
+		now:      time.Now,
+	}
+}
+
+// List returns the accounts for host, or for all hosts if host is empty, ordered by host and login.
+func (m *Manager) List(host string) ([]Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Account
+	for _, e := range entries {
+		if host == "" || e.Account.Host == normalizeHost(host) {
+			list = append(list, e.Account)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Host != list[j].Host {
+			return list[i].Host < list[j].Host
+		}
+		return list[i].Login < list[j].Login
+	})
+	return list, nil
+}
+
+// Login runs flow to obtain a token for host and adds it with Add.
+func (m *Manager) Login(ctx context.Context, host string, flow *oauth.Flow) (Account, error) {
+	token, err := flow.DetectFlowContext(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return m.Add(ctx, host, token)
+}
+
+// Add stores token for the account it belongs to on host and makes that account active. If the
+// account exists already, its token is replaced.
+func (m *Manager) Add(ctx context.Context, host string, token *api.AccessToken) (Account, error) {
+	host = normalizeHost(host)
+	login, id, err := m.identify(ctx, host, token)
+	if err != nil {
+		return Account{}, fmt.Errorf("could not identify the account: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return Account{}, err
+	}
+
+	account := Account{Host: host, Login: login, ID: id, Active: true}
+	replaced := false
+	for i := range entries {
+		if entries[i].Account.Host != host {
+			continue
+		}
+		if sameAccount(entries[i].Account, account) {
+			entries[i] = Entry{Account: account, Token: token, ObtainedAt: m.now()}
+			replaced = true
+		} else {
+			entries[i].Account.Active = false
+		}
+	}
+	if !replaced {
+		entries = append(entries, Entry{Account: account, Token: token, ObtainedAt: m.now()})
+	}
+
+	return account, m.store.Save(entries)
+}
+
+// Switch makes the account with login the active one for host.
+func (m *Manager) Switch(host, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	host = normalizeHost(host)
+	i := findEntry(entries, host, login)
+	if i < 0 {
+		return fmt.Errorf("%w: %s on %s", ErrNotFound, login, host)
+	}
+	for j := range entries {
+		if entries[j].Account.Host == host {
+			entries[j].Account.Active = j == i
+		}
+	}
+	return m.store.Save(entries)
+}
+
+// Remove deletes the account with login on host along with its token. If it was the active account,
+// another account for host, if any, becomes active.
+func (m *Manager) Remove(host, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+
+	host = normalizeHost(host)
+	i := findEntry(entries, host, login)
+	if i < 0 {
+		return fmt.Errorf("%w: %s on %s", ErrNotFound, login, host)
+	}
+	wasActive := entries[i].Account.Active
+	entries = append(entries[:i], entries[i+1:]...)
+
+	if wasActive {
+		for j := range entries {
+			if entries[j].Account.Host == host {
+				entries[j].Account.Active = true
+				break
+			}
+		}
+	}
+	return m.store.Save(entries)
+}
+
+// Get returns the account with login on host along with its stored token.
+func (m *Manager) Get(host, login string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	host = normalizeHost(host)
+	i := findEntry(entries, host, login)
+	if i < 0 {
+		return Entry{}, fmt.Errorf("%w: %s on %s", ErrNotFound, login, host)
+	}
+	return entries[i], nil
+}
+
+// Active returns the active account for host along with its stored token.
+func (m *Manager) Active(host string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	host = normalizeHost(host)
+	for _, e := range entries {
+		if e.Account.Host == host && e.Account.Active {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("%w: no active account on %s", ErrNotFound, host)
+}
+
+// refreshMargin is how long before its expiry Token renews an access token, so that it does not
+// expire while in use.
+const refreshMargin = time.Minute
+
+// Token returns the access token of the active account for host. If the token is about to expire,
+// it is renewed with Refresh first.
+func (m *Manager) Token(ctx context.Context, host string, flow *oauth.Flow) (*api.AccessToken, error) {
+	entry, err := m.activeToken(ctx, host, flow)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Token, nil
+}
+
+// TokenOrLogin returns the active account for host along with its access token, renewed as by Token.
+// If there is no active account, or its token has expired for good, and interactive is set, the user
+// logs in with flow first. Otherwise, the error wraps ErrNotFound or ErrExpired.
+func (m *Manager) TokenOrLogin(ctx context.Context, host string, flow *oauth.Flow, interactive bool) (Entry, error) {
+	entry, err := m.activeToken(ctx, host, flow)
+	if interactive && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired)) {
+		if _, err := m.Login(ctx, host, flow); err != nil {
+			return Entry{}, err
+		}
+		entry, err = m.activeToken(ctx, host, flow)
+	}
+	return entry, err
+}
+
+// activeToken returns the active account for host, after renewing its token if it is about to expire.
+func (m *Manager) activeToken(ctx context.Context, host string, flow *oauth.Flow) (Entry, error) {
+	entry, err := m.Active(host)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !m.expiring(entry) {
+		return entry, nil
+	}
+	return m.refresh(ctx, entry.Account, flow, true)
+}
+
+// Refresh renews the access token of the active account for host using its refresh token and flow,
+// and stores the new token. Refreshes of the same account through the Manager do not overlap, so
+// that a refresh token that the server rotates is never used twice.
+func (m *Manager) Refresh(ctx context.Context, host string, flow *oauth.Flow) (Entry, error) {
+	entry, err := m.Active(host)
+	if err != nil {
+		return Entry{}, err
+	}
+	return m.refresh(ctx, entry.Account, flow, false)
+}
+
+// refresh renews the access token of account while holding its refresh lock. It starts from the
+// stored token rather than the caller's copy, which a concurrent refresh may have replaced. If
+// onlyExpiring is set, a token that is no longer about to expire is returned as is.
+func (m *Manager) refresh(ctx context.Context, account Account, flow *oauth.Flow, onlyExpiring bool) (Entry, error) {
+	lock := m.refreshLock(account)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := m.Get(account.Host, account.Login)
+	if err != nil {
+		return Entry{}, err
+	}
+	if onlyExpiring {
+		if !m.expiring(entry) {
+			return entry, nil
+		}
+		if !entry.Refreshable(m.now()) {
+			return Entry{}, fmt.Errorf("%w for %s on %s; log in again", ErrExpired, entry.Account.Login, entry.Account.Host)
+		}
+	}
+	if entry.Token == nil || entry.Token.RefreshToken == "" {
+		return Entry{}, fmt.Errorf("the token for %s on %s can not be refreshed", entry.Account.Login, entry.Account.Host)
+	}
+
+	token, err := flow.RefreshContext(ctx, entry.Token.RefreshToken)
+	if err != nil {
+		return Entry{}, fmt.Errorf("could not refresh the token: %w", err)
+	}
+	if token.RefreshToken == "" {
+		// Servers that do not rotate refresh tokens let the old one be used again.
+		token.RefreshToken = entry.Token.RefreshToken
+		if expiresIn := entry.Token.RefreshTokenExpiresIn; expiresIn > 0 {
+			expiresAt := entry.ObtainedAt.Add(time.Duration(expiresIn) * time.Second)
+			token.RefreshTokenExpiresIn = int(expiresAt.Sub(m.now()) / time.Second)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.store.Load()
+	if err != nil {
+		return Entry{}, err
+	}
+	i := findEntry(entries, entry.Account.Host, entry.Account.Login)
+	if i < 0 {
+		return Entry{}, fmt.Errorf("%w: %s on %s", ErrNotFound, entry.Account.Login, entry.Account.Host)
+	}
+	entries[i].Token = token
+	entries[i].ObtainedAt = m.now()
+	return entries[i], m.store.Save(entries)
+}
+
+// expiring reports whether the access token of e expires within refreshMargin.
+func (m *Manager) expiring(e Entry) bool {
+	expiresAt := e.ExpiresAt()
+	return !expiresAt.IsZero() && !m.now().Add(refreshMargin).Before(expiresAt)
+}
+
+// refreshLock returns the lock that serializes the token refreshes of account.
+func (m *Manager) refreshLock(account Account) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := account.Host + "\n" + strings.ToLower(account.Login)
+	lock, ok := m.refreshing[key]
+	if !ok {
+		if m.refreshing == nil {
+			m.refreshing = make(map[string]*sync.Mutex)
+		}
+		lock = &sync.Mutex{}
+		m.refreshing[key] = lock
+	}
+	return lock
+}
+
+func findEntry(entries []Entry, host, login string) int {
+	for i, e := range entries {
+		if e.Account.Host == host && strings.EqualFold(e.Account.Login, login) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameAccount reports whether a and b are the same user, preferring the stable ID over the login,
+// which can be renamed.
+func sameAccount(a, b Account) bool {
+	if a.ID != "" && b.ID != "" {
+		return a.ID == b.ID
+	}
+	return strings.EqualFold(a.Login, b.Login)
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSpace(host))
+}
@@ -0,0 +1,283 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+// tokenIdentity identifies tokens of the form "<login>:<id>".
+func tokenIdentity(_ context.Context, _ string, token *api.AccessToken) (string, string, error) {
+	login, id, ok := strings.Cut(token.Token, ":")
+	if !ok {
+		return "", "", errors.New("bad credentials")
+	}
+	return login, id, nil
+}
+
+func newTestManager() *Manager {
+	m := NewManager(&MemoryStore{}, tokenIdentity)
+	m.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return m
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager()
+
+	for _, add := range []struct{ host, token string }{
+		{"github.com", "monalisa:1"},
+		{"GHE.example.com", "monalisa:7"},
+		{"github.com", "hubot:2"},
+		{"ghe.example.com", "octocat:8"},
+	} {
+		if _, err := m.Add(ctx, add.host, &api.AccessToken{Token: add.token}); err != nil {
+			t.Fatalf("Add(%q) error: %v", add.token, err)
+		}
+	}
+
+	list, err := m.List("")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	want := []Account{
+		{Host: "ghe.example.com", Login: "monalisa", ID: "7"},
+		{Host: "ghe.example.com", Login: "octocat", ID: "8", Active: true},
+		{Host: "github.com", Login: "hubot", ID: "2", Active: true},
+		{Host: "github.com", Login: "monalisa", ID: "1"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("List() = %+v, want %+v", list, want)
+	}
+
+	if err := m.Switch("github.com", "monalisa"); err != nil {
+		t.Fatalf("Switch() error: %v", err)
+	}
+	active, err := m.Active("github.com")
+	if err != nil {
+		t.Fatalf("Active() error: %v", err)
+	}
+	if active.Account.Login != "monalisa" || active.Token.Token != "monalisa:1" {
+		t.Errorf("Active() = %+v", active)
+	}
+	if !active.ObtainedAt.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("ObtainedAt = %v", active.ObtainedAt)
+	}
+	if entry, err := m.Get("GitHub.com", "HUBOT"); err != nil || entry.Token.Token != "hubot:2" || entry.Account.Active {
+		t.Errorf("Get() = %+v, %v", entry, err)
+	}
+
+	// Removing the active account activates another one on the same host.
+	if err := m.Remove("github.com", "monalisa"); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	active, err = m.Active("github.com")
+	if err != nil {
+		t.Fatalf("Active() error: %v", err)
+	}
+	if active.Account.Login != "hubot" {
+		t.Errorf("Active() = %+v", active.Account)
+	}
+	if list, _ := m.List("ghe.example.com"); len(list) != 2 {
+		t.Errorf("List(ghe.example.com) = %+v", list)
+	}
+}
+
+func TestManager_Add_renamedAccount(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager()
+
+	if _, err := m.Add(ctx, "github.com", &api.AccessToken{Token: "monalisa:1"}); err != nil {
+		t.Fatal(err)
+	}
+	account, err := m.Add(ctx, "github.com", &api.AccessToken{Token: "mona:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Login != "mona" {
+		t.Errorf("Add() = %+v", account)
+	}
+
+	list, _ := m.List("github.com")
+	if len(list) != 1 || list[0].Login != "mona" || !list[0].Active {
+		t.Errorf("List() = %+v", list)
+	}
+}
+
+func TestManager_errors(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager()
+
+	if _, err := m.Add(ctx, "github.com", &api.AccessToken{Token: "garbage"}); err == nil || err.Error() != "could not identify the account: bad credentials" {
+		t.Errorf("Add() error = %v", err)
+	}
+	if err := m.Switch("github.com", "monalisa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Switch() error = %v", err)
+	}
+	if err := m.Remove("github.com", "monalisa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := m.Active("github.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Active() error = %v", err)
+	}
+	if _, err := m.Get("github.com", "monalisa"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v", err)
+	}
+}
+
+func TestManager_Token(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{TokenExpiresIn: 3600})
+	defer s.Close()
+
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := &oauth.Flow{
+		Host:        host,
+		ClientID:    "CLIENT-ID",
+		DisplayCode: func(string, string) error { return nil },
+		BrowseURL:   func(string) error { return nil },
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := NewManager(&MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+		return "monalisa", "1", nil
+	})
+	m.now = func() time.Time { return now }
+
+	if _, err := m.Login(ctx, "github.com", flow); err != nil {
+		t.Fatalf("Login() error: %v", err)
+	}
+	first, err := m.Token(ctx, "github.com", flow)
+	if err != nil {
+		t.Fatalf("Token() error: %v", err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if token, err := m.Token(ctx, "github.com", flow); err != nil || token.Token != first.Token {
+		t.Errorf("Token() = %v, %v; want the stored token", token, err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	refreshed, err := m.Token(ctx, "github.com", flow)
+	if err != nil {
+		t.Fatalf("Token() error: %v", err)
+	}
+	if refreshed.Token == first.Token || !s.Valid(refreshed.Token) {
+		t.Errorf("Token() = %v, want a new token", refreshed)
+	}
+	active, _ := m.Active("github.com")
+	if active.Token.Token != refreshed.Token || !active.ExpiresAt().Equal(now.Add(time.Hour)) {
+		t.Errorf("Active() = %+v", active)
+	}
+
+	// Without a refresh token, the user has to log in again.
+	if _, err := m.Add(ctx, "github.com", &api.AccessToken{Token: refreshed.Token, ExpiresIn: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Token(ctx, "github.com", flow); !errors.Is(err, ErrExpired) {
+		t.Errorf("Token() error = %v", err)
+	}
+}
+
+func TestManager_Token_concurrent(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{TokenExpiresIn: 3600})
+	defer s.Close()
+
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := &oauth.Flow{
+		Host:        host,
+		ClientID:    "CLIENT-ID",
+		DisplayCode: func(string, string) error { return nil },
+		BrowseURL:   func(string) error { return nil },
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m := NewManager(&MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+		return "monalisa", "1", nil
+	})
+	m.now = func() time.Time { return now }
+
+	if _, err := m.Login(ctx, "github.com", flow); err != nil {
+		t.Fatalf("Login() error: %v", err)
+	}
+	now = now.Add(time.Hour)
+
+	// The server rotates refresh tokens, so only one of the callers may use the stored one; the
+	// others have to pick up the token it obtained.
+	const callers = 8
+	tokens := make([]*api.AccessToken, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = m.Token(ctx, "github.com", flow)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("Token() error: %v", errs[i])
+		}
+		if tokens[i].Token != tokens[0].Token {
+			t.Errorf("Token() = %q, want %q", tokens[i].Token, tokens[0].Token)
+		}
+	}
+	if !s.Valid(tokens[0].Token) {
+		t.Errorf("Token() = %q, want a valid token", tokens[0].Token)
+	}
+}
+
+func TestManager_TokenOrLogin(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{})
+	defer s.Close()
+
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := &oauth.Flow{
+		Host:        host,
+		ClientID:    "CLIENT-ID",
+		DisplayCode: func(string, string) error { return nil },
+		BrowseURL:   func(string) error { return nil },
+	}
+	m := NewManager(&MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+		return "monalisa", "1", nil
+	})
+
+	if _, err := m.TokenOrLogin(ctx, "github.com", flow, false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("TokenOrLogin() error = %v, want ErrNotFound", err)
+	}
+	entry, err := m.TokenOrLogin(ctx, "github.com", flow, true)
+	if err != nil {
+		t.Fatalf("TokenOrLogin() error: %v", err)
+	}
+	if entry.Account.Login != "monalisa" || !s.Valid(entry.Token.Token) {
+		t.Errorf("TokenOrLogin() = %+v", entry)
+	}
+
+	// The stored token is used without logging in again.
+	again, err := m.TokenOrLogin(ctx, "github.com", flow, true)
+	if err != nil || again.Token.Token != entry.Token.Token {
+		t.Errorf("TokenOrLogin() = %+v, %v; want the stored token", again, err)
+	}
+}
@@ -0,0 +1,48 @@
+package accounts_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+)
+
+// Log in to a GitHub host and keep the account alongside those already logged in to.
+func ExampleManager_Login() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	manager := accounts.NewManager(
+		&accounts.FileStore{Path: filepath.Join(configDir, "my-app", "accounts.json")},
+		accounts.GitHubIdentity(http.DefaultClient),
+	)
+
+	host, err := oauth.NewGitHubHost("https://ghe.example.com")
+	if err != nil {
+		panic(err)
+	}
+	flow := &oauth.Flow{
+		Host:     host,
+		ClientID: os.Getenv("OAUTH_CLIENT_ID"),
+		Scopes:   []string{"repo", "read:org"},
+	}
+
+	account, err := manager.Login(context.TODO(), "ghe.example.com", flow)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Logged in to %s as %s\n", account.Host, account.Login)
+
+	list, err := manager.List("")
+	if err != nil {
+		panic(err)
+	}
+	for _, a := range list {
+		fmt.Printf("%s\t%s\tactive=%v\n", a.Host, a.Login, a.Active)
+	}
+}
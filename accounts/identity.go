@@ -0,0 +1,60 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cli/oauth/api"
+)
+
+// IdentityFunc looks up the login and the unique ID of the user that token belongs to on host.
+type IdentityFunc func(ctx context.Context, host string, token *api.AccessToken) (login, id string, err error)
+
+// GitHubIdentity returns an IdentityFunc that looks up the user through the REST API of github.com
+// or a GitHub Enterprise Server instance, using c to make requests.
+func GitHubIdentity(c api.Doer) IdentityFunc {
+	return func(ctx context.Context, host string, token *api.AccessToken) (string, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gitHubAPIURL(host)+"/user", nil)
+		if err != nil {
+			return "", "", err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+
+		resp, err := c.Do(req)
+		if err != nil {
+			return "", "", err
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode != http.StatusOK {
+			return "", "", fmt.Errorf("looking up the user on %s: HTTP %d", host, resp.StatusCode)
+		}
+
+		var user struct {
+			Login string `json:"login"`
+			ID    int64  `json:"id"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, api.DefaultMaxBodySize)).Decode(&user); err != nil {
+			return "", "", fmt.Errorf("looking up the user on %s: %w", host, err)
+		}
+		if user.Login == "" {
+			return "", "", fmt.Errorf("looking up the user on %s: no login in response", host)
+		}
+		return user.Login, strconv.FormatInt(user.ID, 10), nil
+	}
+}
+
+// gitHubAPIURL returns the REST API root for a GitHub host name.
+func gitHubAPIURL(host string) string {
+	if strings.EqualFold(host, "github.com") {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
@@ -0,0 +1,87 @@
+package accounts
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/cli/oauth/api"
+)
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestGitHubIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		status    int
+		body      string
+		wantURL   string
+		wantLogin string
+		wantID    string
+		wantErr   string
+	}{
+		{
+			name:      "github.com",
+			host:      "github.com",
+			status:    200,
+			body:      `{"login":"monalisa","id":583231}`,
+			wantURL:   "https://api.github.com/user",
+			wantLogin: "monalisa",
+			wantID:    "583231",
+		},
+		{
+			name:      "enterprise server",
+			host:      "ghe.example.com",
+			status:    200,
+			body:      `{"login":"octocat","id":42}`,
+			wantURL:   "https://ghe.example.com/api/v3/user",
+			wantLogin: "octocat",
+			wantID:    "42",
+		},
+		{
+			name:    "bad credentials",
+			host:    "github.com",
+			status:  401,
+			body:    `{"message":"Bad credentials"}`,
+			wantURL: "https://api.github.com/user",
+			wantErr: "looking up the user on github.com: HTTP 401",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			identify := GitHubIdentity(doerFunc(func(r *http.Request) (*http.Response, error) {
+				req = r
+				return &http.Response{
+					StatusCode: tt.status,
+					Body:       io.NopCloser(bytes.NewBufferString(tt.body)),
+				}, nil
+			}))
+
+			login, id, err := identify(context.Background(), tt.host, &api.AccessToken{Token: "TOKEN"})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if login != tt.wantLogin || id != tt.wantID {
+				t.Errorf("got %q, %q", login, id)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("requested %s", req.URL)
+			}
+			if got := req.Header.Get("Authorization"); got != "Bearer TOKEN" {
+				t.Errorf("Authorization = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package accounts
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cli/oauth/api"
+)
+
+// Entry is an account together with its stored access token.
+type Entry struct {
+	Account Account
+	Token   *api.AccessToken
+	// ObtainedAt is when the token was issued, which is needed to tell when it expires.
+	ObtainedAt time.Time
+}
+
+// ExpiresAt returns when the access token expires, or the zero time if it does not expire.
+func (e Entry) ExpiresAt() time.Time {
+	if e.Token == nil || e.Token.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return e.ObtainedAt.Add(time.Duration(e.Token.ExpiresIn) * time.Second)
+}
+
+// Refreshable reports whether the access token can be renewed using its refresh token without
+// signing in again.
+func (e Entry) Refreshable(now time.Time) bool {
+	if e.Token == nil || e.Token.RefreshToken == "" {
+		return false
+	}
+	if e.Token.RefreshTokenExpiresIn <= 0 {
+		return true
+	}
+	return now.Before(e.ObtainedAt.Add(time.Duration(e.Token.RefreshTokenExpiresIn) * time.Second))
+}
+
+// Store persists the entries of a Manager. Implementations do not need to be safe for concurrent use;
+// the Manager serializes access.
+type Store interface {
+	// Load returns all stored entries, or none if nothing was saved yet.
+	Load() ([]Entry, error)
+	// Save replaces all stored entries.
+	Save([]Entry) error
+}
+
+// MemoryStore keeps entries in memory only, e.g. for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry(nil), s.entries...), nil
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append([]Entry(nil), entries...)
+	return nil
+}
+
+// FileStore keeps entries in a JSON file that only the current user can read, since it contains
+// access tokens.
+type FileStore struct {
+	// Path is the location of the file. Missing parent directories are created on save.
+	Path string
+}
+
+// Load implements Store.
+func (s *FileStore) Load() ([]Entry, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Save implements Store. The file is replaced atomically, so that readers never see a partial write.
+func (s *FileStore) Save(entries []Entry) error {
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if err := f.Chmod(0o600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
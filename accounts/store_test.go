@@ -0,0 +1,69 @@
+package accounts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth/api"
+)
+
+func TestFileStore(t *testing.T) {
+	s := &FileStore{Path: filepath.Join(t.TempDir(), "oauth", "accounts.json")}
+
+	entries, err := s.Load()
+	if err != nil || entries != nil {
+		t.Fatalf("Load() = %v, %v; want nothing", entries, err)
+	}
+
+	want := []Entry{
+		{
+			Account:    Account{Host: "github.com", Login: "monalisa", ID: "1", Active: true},
+			Token:      &api.AccessToken{Token: "gho_SEKRIT", Type: "bearer", Scope: "repo", ExpiresIn: 28800},
+			ObtainedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+	}
+	if err := s.Save(want); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	got, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %+v, want %+v", got, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(s.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0o600 {
+			t.Errorf("file mode = %v", perm)
+		}
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(s.Path), "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := &MemoryStore{}
+	entries := []Entry{{Account: Account{Host: "github.com", Login: "monalisa"}}}
+	if err := s.Save(entries); err != nil {
+		t.Fatal(err)
+	}
+	entries[0].Account.Login = "changed"
+
+	got, _ := s.Load()
+	if len(got) != 1 || got[0].Account.Login != "monalisa" {
+		t.Errorf("Load() = %+v", got)
+	}
+}
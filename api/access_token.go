@@ -1,5 +1,10 @@
 package api
 
+import (
+	"log/slog"
+	"strings"
+)
+
 // AccessToken is an OAuth access token.
 type AccessToken struct {
 	// The token value, typically a 40-character random string.
@@ -10,16 +15,33 @@ type AccessToken struct {
 	Type string
 	// Space-separated list of OAuth scopes that this token grants.
 	Scope string
+	// The number of seconds until the token expires, or 0 if the server did not specify an expiry.
+	ExpiresIn int
+	// The number of seconds until the refresh token expires, or 0 if the server did not specify an expiry.
+	RefreshTokenExpiresIn int
+}
+
+// LogValue implements slog.LogValuer so that logging a token never reveals its secret values.
+func (t AccessToken) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("type", t.Type),
+		slog.String("scope", t.Scope),
+		slog.Bool("refreshable", t.RefreshToken != ""),
+	)
 }
 
 // AccessToken extracts the access token information from a server response.
 func (f FormResponse) AccessToken() (*AccessToken, error) {
 	if accessToken := f.Get("access_token"); accessToken != "" {
+		expiresIn, _ := f.GetInt("expires_in")
+		refreshTokenExpiresIn, _ := f.GetInt("refresh_token_expires_in")
 		return &AccessToken{
-			Token:        accessToken,
-			RefreshToken: f.Get("refresh_token"),
-			Type:         f.Get("token_type"),
-			Scope:        f.Get("scope"),
+			Token:                 accessToken,
+			RefreshToken:          f.Get("refresh_token"),
+			Type:                  f.Get("token_type"),
+			Scope:                 strings.Join(f.GetStrings("scope"), " "),
+			ExpiresIn:             int(expiresIn),
+			RefreshTokenExpiresIn: int(refreshTokenExpiresIn),
 		}, nil
 	}
 
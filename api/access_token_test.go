@@ -48,6 +48,28 @@ func TestFormResponse_AccessToken(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "with expiry and scope array",
+			response: FormResponse{
+				values: url.Values{
+					"access_token":             []string{"ATOKEN"},
+					"refresh_token":            []string{"AREFRESHTOKEN"},
+					"token_type":               []string{"bearer"},
+					"scope":                    []string{"repo", "gist"},
+					"expires_in":               []string{"28800"},
+					"refresh_token_expires_in": []string{"15897600"},
+				},
+			},
+			want: &AccessToken{
+				Token:                 "ATOKEN",
+				RefreshToken:          "AREFRESHTOKEN",
+				Type:                  "bearer",
+				Scope:                 "repo gist",
+				ExpiresIn:             28800,
+				RefreshTokenExpiresIn: 15897600,
+			},
+			wantErr: nil,
+		},
 		{
 			name: "no token",
 			response: FormResponse{
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Doer performs HTTP requests. It is satisfied by *http.Client.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// FormPoster is the HTTP client interface that was accepted by earlier versions of this module. Use
+// FromFormPoster to pass an implementation where a Doer is required.
+type FormPoster interface {
+	PostForm(string, url.Values) (*http.Response, error)
+}
+
+// FromFormPoster adapts c into a Doer. The adapter only supports POST requests with a form-encoded
+// body, and it does not pass the request context, headers or credentials on to c.
+func FromFormPoster(c FormPoster) Doer {
+	return formPosterDoer{c: c}
+}
+
+type formPosterDoer struct {
+	c FormPoster
+}
+
+func (d formPosterDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost {
+		return nil, fmt.Errorf("unsupported request method %s", req.Method)
+	}
+
+	params := url.Values{}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		params, err = url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d.c.PostForm(req.URL.String(), params)
+}
+
+// DefaultMaxBodySize is the default limit on the size of response bodies read by PostForm.
+const DefaultMaxBodySize = 1 << 20
+
+// RequestOptions customizes the HTTP requests made by PostForm.
+type RequestOptions struct {
+	// UserAgent is sent as the "User-Agent" request header, if set.
+	UserAgent string
+	// Header holds additional request headers, e.g. "Accept: application/json".
+	Header http.Header
+	// BasicAuth authenticates the request using HTTP Basic authentication, if set.
+	BasicAuth *BasicAuth
+	// MaxBodySize is the maximum number of response body bytes that are read. Defaults to
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+// BasicAuth holds the credentials for HTTP Basic authentication, such as an OAuth client ID and secret.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (o RequestOptions) apply(req *http.Request) {
+	for k, vs := range o.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+	}
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+	if o.BasicAuth != nil {
+		req.SetBasicAuth(o.BasicAuth.Username, o.BasicAuth.Password)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+// ErrorCode is an error code returned by an OAuth server in the "error" response parameter. The
+// constants of this type serve as sentinel errors: an *Error matches the ErrorCode equal to its Code
+// when compared using errors.Is.
+type ErrorCode string
+
+func (c ErrorCode) Error() string {
+	return string(c)
+}
+
+// Error codes defined by the OAuth 2.0 Authorization Framework (RFC 6749, sections 4.1.2.1 and 5.2).
+const (
+	ErrInvalidRequest          ErrorCode = "invalid_request"
+	ErrInvalidClient           ErrorCode = "invalid_client"
+	ErrInvalidGrant            ErrorCode = "invalid_grant"
+	ErrUnauthorizedClient      ErrorCode = "unauthorized_client"
+	ErrUnsupportedGrantType    ErrorCode = "unsupported_grant_type"
+	ErrUnsupportedResponseType ErrorCode = "unsupported_response_type"
+	ErrInvalidScope            ErrorCode = "invalid_scope"
+	ErrAccessDenied            ErrorCode = "access_denied"
+	ErrServerError             ErrorCode = "server_error"
+	ErrTemporarilyUnavailable  ErrorCode = "temporarily_unavailable"
+)
+
+// Error codes defined by the OAuth 2.0 Device Authorization Grant (RFC 8628, section 3.5).
+const (
+	ErrAuthorizationPending ErrorCode = "authorization_pending"
+	ErrSlowDown             ErrorCode = "slow_down"
+	ErrExpiredToken         ErrorCode = "expired_token"
+)
+
+// Error codes returned by GitHub in addition to the standard ones.
+const (
+	ErrIncorrectClientCredentials ErrorCode = "incorrect_client_credentials"
+	ErrRedirectURIMismatch        ErrorCode = "redirect_uri_mismatch"
+	ErrBadVerificationCode        ErrorCode = "bad_verification_code"
+	ErrUnverifiedUserEmail        ErrorCode = "unverified_user_email"
+	ErrIncorrectDeviceCode        ErrorCode = "incorrect_device_code"
+	ErrDeviceFlowDisabled         ErrorCode = "device_flow_disabled"
+	ErrApplicationSuspended       ErrorCode = "application_suspended"
+)
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxSnippetSize is the number of bytes of an unexpected response body kept for diagnostics.
+const maxSnippetSize = 512
+
+// ErrResponseTooLarge is returned by PostForm when the response body exceeds the configured limit.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ContentTypeError describes a response with a content type other than a form or JSON, such as an
+// HTML error page served by a proxy or by the server itself. It is available as the cause of the
+// *Error returned by FormResponse.Err.
+type ContentTypeError struct {
+	// ContentType is the value of the "Content-Type" response header.
+	ContentType string
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// RequestURI is the URL that the request was posted to.
+	RequestURI string
+	// Snippet holds up to the first 512 bytes of the response body.
+	Snippet string
+}
+
+var (
+	htmlTagRE    = regexp.MustCompile(`(?s)<(script|style)\b.*?</(script|style)>|<[^>]*>`)
+	whitespaceRE = regexp.MustCompile(`\s+`)
+)
+
+func (e *ContentTypeError) Error() string {
+	contentType := e.ContentType
+	if contentType == "" {
+		contentType = "no content type"
+	}
+	msg := fmt.Sprintf("server responded with %s instead of a form or JSON", contentType)
+	if summary := e.summary(); summary != "" {
+		msg += ": " + summary
+	}
+	return msg
+}
+
+// summary condenses the snippet into a single line of text, stripping any markup.
+func (e *ContentTypeError) summary() string {
+	text := htmlTagRE.ReplaceAllString(e.Snippet, " ")
+	text = strings.TrimSpace(whitespaceRE.ReplaceAllString(text, " "))
+	const maxLen = 120
+	if len(text) > maxLen {
+		text = strings.ToValidUTF8(text[:maxLen], "") + "..."
+	}
+	return text
+}
+
+// RateLimitError is returned by FormResponse.Err when the server rejected a request because the client
+// made too many requests, signaled by HTTP 429 or by HTTP 403 with rate limit headers. Callers may wait
+// until Reset and try again instead of failing. The response error is available through Unwrap.
+type RateLimitError struct {
+	// Reset is when the server is expected to accept requests again, based on the "Retry-After" or
+	// "X-RateLimit-Reset" response headers. It is zero if the server did not say.
+	Reset time.Time
+	// Limit is the value of the "X-RateLimit-Limit" response header, or -1 if absent.
+	Limit int
+	// Remaining is the value of the "X-RateLimit-Remaining" response header, or -1 if absent.
+	Remaining int
+	// Err is the error parsed from the response body.
+	Err *Error
+}
+
+func (e *RateLimitError) Error() string {
+	msg := "rate limit exceeded"
+	if !e.Reset.IsZero() {
+		msg += fmt.Sprintf("; try again after %s", e.Reset.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
@@ -1,50 +1,196 @@
 // Package api implements request and response parsing logic shared between different OAuth strategies.
+//
+// Functions across this module that make HTTP requests, such as PostForm, device.RequestCode,
+// device.Wait and webapp.Flow.AccessToken, take a Doer, which *http.Client satisfies. Earlier versions
+// took any client with a PostForm(string, url.Values) method instead. Such a client keeps working when
+// wrapped with FromFormPoster, e.g. device.RequestCode(api.FromFormPoster(c), ...), but the requests it
+// makes do not carry the context, the headers of RequestOptions or basic auth.
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
-type httpClient interface {
-	PostForm(string, url.Values) (*http.Response, error)
-}
-
-// FormResponse is the parsed "www-form-urlencoded" response from the server.
+// FormResponse is the parsed "www-form-urlencoded" or JSON response from the server.
 type FormResponse struct {
 	StatusCode int
+	// Header holds the HTTP response headers, e.g. "Retry-After" or "X-RateLimit-Reset".
+	Header http.Header
 
 	requestURI string
 	values     url.Values
+	document   json.RawMessage
+	contentErr *ContentTypeError
 }
 
-// Get the response value named k.
+// Get the response value named k. For JSON responses, numbers and booleans are returned in their
+// textual form and the first element is returned for arrays.
 func (f FormResponse) Get(k string) string {
 	return f.values.Get(k)
 }
 
-// Err returns an Error object extracted from the response.
+// GetInt returns the response value named k parsed as an integer.
+func (f FormResponse) GetInt(k string) (int64, error) {
+	return strconv.ParseInt(f.Get(k), 10, 64)
+}
+
+// GetBool returns the response value named k parsed as a boolean.
+func (f FormResponse) GetBool(k string) (bool, error) {
+	return strconv.ParseBool(f.Get(k))
+}
+
+// GetStrings returns all response values named k, such as the elements of a JSON array or the
+// repeated values of a form-encoded key.
+func (f FormResponse) GetStrings(k string) []string {
+	return f.values[k]
+}
+
+// Decode unmarshals the response into the value pointed to by into, like json.Unmarshal. JSON
+// responses are decoded losslessly, including nested objects. Values of form-encoded responses are
+// decoded as strings, or as arrays of strings for repeated keys.
+func (f FormResponse) Decode(into any) error {
+	document := f.document
+	if document == nil {
+		fields := make(map[string]any, len(f.values))
+		for k, vs := range f.values {
+			if len(vs) == 1 {
+				fields[k] = vs[0]
+			} else {
+				fields[k] = vs
+			}
+		}
+		var err error
+		if document, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(document, into)
+}
+
+// LogValue implements slog.LogValuer. Secret values in the response are redacted.
+func (f FormResponse) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("status", f.StatusCode),
+		slog.Any("values", redactValues(f.values)),
+	)
+}
+
+// RetryAfter returns how long the server asked the client to wait before making another request, as
+// indicated by the "Retry-After" response header. It returns zero if the header is absent or invalid.
+func (f FormResponse) RetryAfter(now time.Time) time.Duration {
+	v := f.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// Err returns an Error object extracted from the response. If the server rejected the request due
+// to rate limiting, the Error is wrapped in a *RateLimitError.
 func (f FormResponse) Err() error {
-	return &Error{
+	err := f.apiError()
+	if rateLimit := f.rateLimit(time.Now(), err); rateLimit != nil {
+		return rateLimit
+	}
+	return err
+}
+
+// RateLimit returns a *RateLimitError if the server rejected the request because the client exceeded
+// its rate limit, or nil otherwise. A reset time given in relative terms is computed from now.
+func (f FormResponse) RateLimit(now time.Time) *RateLimitError {
+	return f.rateLimit(now, f.apiError())
+}
+
+func (f FormResponse) apiError() *Error {
+	err := &Error{
 		RequestURI:   f.requestURI,
 		ResponseCode: f.StatusCode,
 		Code:         f.Get("error"),
+		ErrorURI:     f.Get("error_uri"),
 		message:      f.Get("error_description"),
 	}
+	if f.contentErr != nil {
+		err.cause = f.contentErr
+	}
+	return err
+}
+
+func (f FormResponse) rateLimit(now time.Time, err *Error) *RateLimitError {
+	remaining := headerInt(f.Header, "X-RateLimit-Remaining")
+	limited := f.StatusCode == http.StatusTooManyRequests ||
+		(f.StatusCode == http.StatusForbidden && (f.Header.Get("Retry-After") != "" || remaining == 0))
+	if !limited {
+		return nil
+	}
+
+	rateLimit := &RateLimitError{
+		Limit:     headerInt(f.Header, "X-RateLimit-Limit"),
+		Remaining: remaining,
+		Err:       err,
+	}
+	if retryAfter := f.RetryAfter(now); retryAfter > 0 {
+		rateLimit.Reset = now.Add(retryAfter)
+	} else if reset := headerInt(f.Header, "X-RateLimit-Reset"); reset > 0 {
+		rateLimit.Reset = time.Unix(int64(reset), 0)
+	}
+	return rateLimit
+}
+
+func headerInt(h http.Header, k string) int {
+	v, err := strconv.Atoi(h.Get(k))
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// ErrorFromValues returns the OAuth error described by the "error", "error_description" and
+// "error_uri" parameters in values, such as those of an authorization redirect, or nil if values has
+// no "error" parameter.
+func ErrorFromValues(values url.Values) *Error {
+	if values.Get("error") == "" {
+		return nil
+	}
+	return &Error{
+		Code:     values.Get("error"),
+		ErrorURI: values.Get("error_uri"),
+		message:  values.Get("error_description"),
+	}
 }
 
 // Error is the result of an unexpected HTTP response from the server.
 type Error struct {
+	// Code is the OAuth error code, e.g. "access_denied". It is matched by the ErrorCode values
+	// declared in this package when using errors.Is.
 	Code         string
 	ResponseCode int
 	RequestURI   string
+	// ErrorURI links to a human-readable page with more information about the error, if provided.
+	ErrorURI string
 
 	message string
+	cause   error
 }
 
 func (e Error) Error() string {
@@ -54,13 +200,40 @@ func (e Error) Error() string {
 	if e.Code != "" {
 		return e.Code
 	}
+	if e.cause != nil {
+		return fmt.Sprintf("HTTP %d: %v", e.ResponseCode, e.cause)
+	}
 	return fmt.Sprintf("HTTP %d", e.ResponseCode)
 }
 
+// Description returns the human-readable description of the error provided by the server, if any.
+func (e Error) Description() string {
+	return e.message
+}
+
+// Unwrap returns the underlying cause of the error, such as a *ContentTypeError, if any.
+func (e Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is the ErrorCode matching the code of this error. This allows checks
+// such as errors.Is(err, api.ErrAccessDenied).
+func (e Error) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && e.Code != "" && string(code) == e.Code
+}
+
 // PostForm makes an POST request by serializing input parameters as a form and parsing the response
-// of the same type.
-func PostForm(c httpClient, u string, params url.Values) (*FormResponse, error) {
-	resp, err := c.PostForm(u, params)
+// of the same type. The request is bound to ctx and customized by opts.
+func PostForm(ctx context.Context, c Doer, u string, params url.Values, opts RequestOptions) (*FormResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	opts.apply(req)
+
+	resp, err := c.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -70,14 +243,21 @@ func PostForm(c httpClient, u string, params url.Values) (*FormResponse, error)
 
 	r := &FormResponse{
 		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
 		requestURI: u,
 	}
 
-	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	maxBodySize := opts.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
 	switch mediaType {
 	case "application/x-www-form-urlencoded":
 		var bb []byte
-		bb, err = io.ReadAll(resp.Body)
+		bb, err = readBody(resp.Body, maxBodySize)
 		if err != nil {
 			return r, err
 		}
@@ -87,24 +267,38 @@ func PostForm(c httpClient, u string, params url.Values) (*FormResponse, error)
 			return r, err
 		}
 	case "application/json":
-		var values map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&values); err != nil {
+		var bb []byte
+		bb, err = readBody(resp.Body, maxBodySize)
+		if err != nil {
 			return r, err
 		}
 
-		r.values = make(url.Values)
-		for key, value := range values {
-			switch v := value.(type) {
-			case string:
-				r.values.Set(key, v)
-			case int64:
-				r.values.Set(key, strconv.FormatInt(v, 10))
-			case float64:
-				r.values.Set(key, strconv.FormatFloat(v, 'f', -1, 64))
-			}
+		var document json.RawMessage
+		if err := json.NewDecoder(bytes.NewReader(bb)).Decode(&document); err != nil {
+			return r, err
 		}
+		r.values, err = jsonValues(document)
+		if err != nil {
+			return r, err
+		}
+		r.document = document
 	default:
-		_, err = io.Copy(io.Discard, resp.Body)
+		snippet := make([]byte, maxSnippetSize)
+		n, err := io.ReadFull(resp.Body, snippet)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return r, err
+		}
+		if n > 0 {
+			r.contentErr = &ContentTypeError{
+				ContentType: contentType,
+				StatusCode:  resp.StatusCode,
+				RequestURI:  u,
+				Snippet:     string(snippet[:n]),
+			}
+		}
+
+		// Drain what is left, within limits, so that the connection can be reused.
+		_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
 		if err != nil {
 			return r, err
 		}
@@ -112,3 +306,59 @@ func PostForm(c httpClient, u string, params url.Values) (*FormResponse, error)
 
 	return r, nil
 }
+
+// readBody reads all of r, failing with ErrResponseTooLarge once more than limit bytes were read.
+func readBody(r io.Reader, limit int64) ([]byte, error) {
+	bb, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bb)) > limit {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, limit)
+	}
+	return bb, nil
+}
+
+// jsonValues flattens the scalar members of a JSON object, and arrays thereof, into url.Values while
+// preserving the textual representation of numbers. Nested objects and nulls are left out; they are
+// only available through Decode.
+func jsonValues(document json.RawMessage) (url.Values, error) {
+	dec := json.NewDecoder(bytes.NewReader(document))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	values := make(url.Values)
+	for key, value := range fields {
+		if s, ok := scalarString(value); ok {
+			values.Set(key, s)
+			continue
+		}
+		elems, ok := value.([]any)
+		if !ok {
+			continue
+		}
+		strs := make([]string, 0, len(elems))
+		for _, elem := range elems {
+			if s, ok := scalarString(elem); ok {
+				strs = append(strs, s)
+			}
+		}
+		values[key] = strs
+	}
+	return values, nil
+}
+
+func scalarString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -2,11 +2,17 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFormResponse_Get(t *testing.T) {
@@ -42,6 +48,71 @@ func TestFormResponse_Get(t *testing.T) {
 	}
 }
 
+func TestFormResponse_typedAccessors(t *testing.T) {
+	values, err := jsonValues(json.RawMessage(`{"expires_in":900,"verified":false,"scope":["repo","gist"],"name":"x"}`))
+	if err != nil {
+		t.Fatalf("jsonValues() error: %v", err)
+	}
+	f := FormResponse{values: values}
+
+	if got, err := f.GetInt("expires_in"); err != nil || got != 900 {
+		t.Errorf("GetInt() = %d, %v", got, err)
+	}
+	if _, err := f.GetInt("name"); err == nil {
+		t.Error("GetInt() expected error for non-integer value")
+	}
+	if got, err := f.GetBool("verified"); err != nil || got {
+		t.Errorf("GetBool() = %v, %v", got, err)
+	}
+	if got := f.GetStrings("scope"); !reflect.DeepEqual(got, []string{"repo", "gist"}) {
+		t.Errorf("GetStrings() = %v", got)
+	}
+	if got := f.GetStrings("missing"); got != nil {
+		t.Errorf("GetStrings() = %v, want nil", got)
+	}
+}
+
+func TestFormResponse_Decode(t *testing.T) {
+	type details struct {
+		Type string `json:"type"`
+	}
+	type payload struct {
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		Details     []details `json:"authorization_details"`
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		document := json.RawMessage(`{"access_token":"ATOKEN","expires_in":900,"authorization_details":[{"type":"repo"}]}`)
+		values, _ := jsonValues(document)
+		f := FormResponse{values: values, document: document}
+
+		var got payload
+		if err := f.Decode(&got); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		want := payload{AccessToken: "ATOKEN", ExpiresIn: 900, Details: []details{{Type: "repo"}}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Decode() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("form", func(t *testing.T) {
+		f := FormResponse{values: url.Values{"access_token": {"ATOKEN"}, "scope": {"repo", "gist"}}}
+
+		var got struct {
+			AccessToken string   `json:"access_token"`
+			Scope       []string `json:"scope"`
+		}
+		if err := f.Decode(&got); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		if got.AccessToken != "ATOKEN" || !reflect.DeepEqual(got.Scope, []string{"repo", "gist"}) {
+			t.Errorf("Decode() = %+v", got)
+		}
+	})
+}
+
 func TestFormResponse_Err(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -63,12 +134,15 @@ func TestFormResponse_Err(t *testing.T) {
 				values: url.Values{
 					"error":             []string{"try_again"},
 					"error_description": []string{"maybe it works later"},
+					"error_uri":         []string{"https://docs.example.com/try_again"},
 				},
 			},
 			wantErr: Error{
 				Code:         "try_again",
 				ResponseCode: 422,
 				RequestURI:   "http://example.com/path",
+				ErrorURI:     "https://docs.example.com/try_again",
+				message:      "maybe it works later",
 			},
 			errorMsg: "maybe it works later (try_again)",
 		},
@@ -102,6 +176,12 @@ func TestFormResponse_Err(t *testing.T) {
 			if apiError.RequestURI != tt.wantErr.RequestURI {
 				t.Errorf("Error.RequestURI = %v, want %v", apiError.RequestURI, tt.wantErr.RequestURI)
 			}
+			if apiError.ErrorURI != tt.wantErr.ErrorURI {
+				t.Errorf("Error.ErrorURI = %v, want %v", apiError.ErrorURI, tt.wantErr.ErrorURI)
+			}
+			if apiError.Description() != tt.wantErr.Description() {
+				t.Errorf("Error.Description() = %q, want %q", apiError.Description(), tt.wantErr.Description())
+			}
 			if apiError.Error() != tt.errorMsg {
 				t.Errorf("Error.Error() = %q, want %q", apiError.Error(), tt.errorMsg)
 			}
@@ -109,16 +189,221 @@ func TestFormResponse_Err(t *testing.T) {
 	}
 }
 
+func TestFormResponse_RetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{
+			name: "absent",
+			want: 0,
+		},
+		{
+			name:   "seconds",
+			header: "120",
+			want:   2 * time.Minute,
+		},
+		{
+			name:   "HTTP date",
+			header: "Wed, 01 May 2024 12:00:30 GMT",
+			want:   30 * time.Second,
+		},
+		{
+			name:   "HTTP date in the past",
+			header: "Wed, 01 May 2024 11:00:00 GMT",
+			want:   0,
+		},
+		{
+			name:   "invalid",
+			header: "soon",
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := FormResponse{Header: http.Header{}}
+			if tt.header != "" {
+				f.Header.Set("Retry-After", tt.header)
+			}
+			if got := f.RetryAfter(now); got != tt.want {
+				t.Errorf("FormResponse.RetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormResponse_RateLimit(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   *RateLimitError
+	}{
+		{
+			name:   "not limited",
+			status: 400,
+			header: http.Header{"X-Ratelimit-Remaining": {"10"}},
+		},
+		{
+			name:   "forbidden without rate limit headers",
+			status: 403,
+		},
+		{
+			name:   "too many requests with Retry-After",
+			status: 429,
+			header: http.Header{"Retry-After": {"30"}},
+			want:   &RateLimitError{Reset: now.Add(30 * time.Second), Limit: -1, Remaining: -1},
+		},
+		{
+			name:   "too many requests without reset",
+			status: 429,
+			want:   &RateLimitError{Limit: -1, Remaining: -1},
+		},
+		{
+			name:   "forbidden with exhausted quota",
+			status: 403,
+			header: http.Header{
+				"X-Ratelimit-Limit":     {"60"},
+				"X-Ratelimit-Remaining": {"0"},
+				"X-Ratelimit-Reset":     {"1714566000"},
+			},
+			want: &RateLimitError{Reset: time.Unix(1714566000, 0), Limit: 60, Remaining: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := FormResponse{StatusCode: tt.status, Header: tt.header}
+			got := f.RateLimit(now)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("FormResponse.RateLimit() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("FormResponse.RateLimit() = nil, want %+v", tt.want)
+			}
+			if !got.Reset.Equal(tt.want.Reset) || got.Limit != tt.want.Limit || got.Remaining != tt.want.Remaining {
+				t.Errorf("FormResponse.RateLimit() = %+v, want %+v", got, tt.want)
+			}
+			if got.Err == nil || got.Err.ResponseCode != tt.status {
+				t.Errorf("FormResponse.RateLimit().Err = %+v", got.Err)
+			}
+		})
+	}
+}
+
+func TestFormResponse_Err_rateLimited(t *testing.T) {
+	f := FormResponse{
+		StatusCode: 429,
+		Header:     http.Header{"Retry-After": {"30"}},
+		requestURI: "https://github.com/login/oauth/access_token",
+		values:     url.Values{"error": {"slow_down"}},
+	}
+	err := f.Err()
+
+	var rateLimit *RateLimitError
+	if !errors.As(err, &rateLimit) {
+		t.Fatalf("Err() = %v, want *RateLimitError", err)
+	}
+	var apiError *Error
+	if !errors.As(err, &apiError) || apiError.Code != "slow_down" {
+		t.Errorf("Err() does not wrap the OAuth error: %v", err)
+	}
+	if !errors.Is(err, ErrSlowDown) {
+		t.Errorf("errors.Is(Err(), ErrSlowDown) = false")
+	}
+	if !strings.HasPrefix(err.Error(), "rate limit exceeded; try again after ") {
+		t.Errorf("Err() = %q", err.Error())
+	}
+}
+
+func TestErrorFromValues(t *testing.T) {
+	if err := ErrorFromValues(url.Values{"code": {"ABC"}}); err != nil {
+		t.Errorf("ErrorFromValues() = %v, want nil", err)
+	}
+
+	err := ErrorFromValues(url.Values{
+		"error":             {"access_denied"},
+		"error_description": {"The user has denied your application access."},
+		"error_uri":         {"https://docs.github.com/errors"},
+	})
+	if err == nil {
+		t.Fatal("ErrorFromValues() = nil")
+	}
+	if err.Error() != "The user has denied your application access. (access_denied)" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if err.ErrorURI != "https://docs.github.com/errors" {
+		t.Errorf("ErrorURI = %q", err.ErrorURI)
+	}
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Error("expected error to match ErrAccessDenied")
+	}
+}
+
+func TestError_Is(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{
+			name:   "matching code",
+			err:    &Error{Code: "access_denied"},
+			target: ErrAccessDenied,
+			want:   true,
+		},
+		{
+			name:   "wrapped",
+			err:    fmt.Errorf("login failed: %w", &Error{Code: "incorrect_client_credentials"}),
+			target: ErrIncorrectClientCredentials,
+			want:   true,
+		},
+		{
+			name:   "different code",
+			err:    &Error{Code: "access_denied"},
+			target: ErrExpiredToken,
+			want:   false,
+		},
+		{
+			name:   "no code",
+			err:    &Error{ResponseCode: 502},
+			target: ErrorCode(""),
+			want:   false,
+		},
+		{
+			name:   "unrelated target",
+			err:    &Error{Code: "access_denied"},
+			target: errors.New("access_denied"),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 type apiClient struct {
 	status      int
 	body        string
 	contentType string
 
 	postCount int
+	request   *http.Request
 }
 
-func (c *apiClient) PostForm(_ string, _ url.Values) (*http.Response, error) {
+func (c *apiClient) Do(req *http.Request) (*http.Response, error) {
 	c.postCount++
+	c.request = req
 	return &http.Response{
 		Body: io.NopCloser(bytes.NewBufferString(c.body)),
 		Header: http.Header{
@@ -153,6 +438,9 @@ func TestPostForm(t *testing.T) {
 			want: &FormResponse{
 				StatusCode: 200,
 				requestURI: "https://github.com/oauth",
+				Header: http.Header{
+					"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"},
+				},
 				values: url.Values{
 					"access_token": {"123abc"},
 					"scopes":       {"repo gist"},
@@ -173,10 +461,42 @@ func TestPostForm(t *testing.T) {
 			want: &FormResponse{
 				StatusCode: 200,
 				requestURI: "https://github.com/oauth",
+				Header: http.Header{
+					"Content-Type": {"application/json; charset=utf-8"},
+				},
 				values: url.Values{
 					"access_token": {"123abc"},
 					"scopes":       {"repo gist"},
 				},
+				document: json.RawMessage(`{"access_token":"123abc", "scopes":"repo gist"}`),
+			},
+			wantErr: false,
+		},
+		{
+			name: "JSON with non-string values",
+			args: args{
+				url: "https://github.com/oauth",
+			},
+			http: apiClient{
+				body:        `{"expires_in":28800,"id":12345678901234567890,"ratio":0.5,"verified":true,"scope":["repo","gist"],"authorization_details":[{"type":"repo"}],"owner":{"login":"monalisa"},"error":null}`,
+				status:      200,
+				contentType: "application/json",
+			},
+			want: &FormResponse{
+				StatusCode: 200,
+				requestURI: "https://github.com/oauth",
+				Header: http.Header{
+					"Content-Type": {"application/json"},
+				},
+				values: url.Values{
+					"expires_in":            {"28800"},
+					"id":                    {"12345678901234567890"},
+					"ratio":                 {"0.5"},
+					"verified":              {"true"},
+					"scope":                 {"repo", "gist"},
+					"authorization_details": {},
+				},
+				document: json.RawMessage(`{"expires_in":28800,"id":12345678901234567890,"ratio":0.5,"verified":true,"scope":["repo","gist"],"authorization_details":[{"type":"repo"}],"owner":{"login":"monalisa"},"error":null}`),
 			},
 			wantErr: false,
 		},
@@ -193,14 +513,23 @@ func TestPostForm(t *testing.T) {
 			want: &FormResponse{
 				StatusCode: 502,
 				requestURI: "https://github.com/oauth",
-				values:     url.Values(nil),
+				Header: http.Header{
+					"Content-Type": {"text/html"},
+				},
+				values: url.Values(nil),
+				contentErr: &ContentTypeError{
+					ContentType: "text/html",
+					StatusCode:  502,
+					RequestURI:  "https://github.com/oauth",
+					Snippet:     "<h1>Something went wrong</h1>",
+				},
 			},
 			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := PostForm(&tt.http, tt.args.url, tt.args.params)
+			got, err := PostForm(context.Background(), &tt.http, tt.args.url, tt.args.params, RequestOptions{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PostForm() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -214,3 +543,151 @@ func TestPostForm(t *testing.T) {
 		})
 	}
 }
+
+func TestPostForm_request(t *testing.T) {
+	client := &apiClient{
+		status:      200,
+		body:        "access_token=123abc",
+		contentType: "application/x-www-form-urlencoded",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := PostForm(ctx, client, "https://github.com/oauth", url.Values{"client_id": {"CLIENT-ID"}}, RequestOptions{
+		UserAgent: "my-cli/1.0",
+		Header: http.Header{
+			"accept": {"application/json"},
+		},
+		BasicAuth: &BasicAuth{Username: "CLIENT-ID", Password: "SEKRIT"},
+	})
+	if err != nil {
+		t.Fatalf("PostForm() error: %v", err)
+	}
+
+	req := client.request
+	if req.Method != http.MethodPost {
+		t.Errorf("Method = %q", req.Method)
+	}
+	if req.Context() != ctx {
+		t.Error("request is not bound to the context")
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/x-www-form-urlencoded" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := req.Header.Get("User-Agent"); got != "my-cli/1.0" {
+		t.Errorf("User-Agent = %q", got)
+	}
+	if got := req.Header.Get("Accept"); got != "application/json" {
+		t.Errorf("Accept = %q", got)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "CLIENT-ID" || pass != "SEKRIT" {
+		t.Errorf("BasicAuth() = %q, %q, %v", user, pass, ok)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "client_id=CLIENT-ID" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+type formPoster struct {
+	url    string
+	params url.Values
+}
+
+func (c *formPoster) PostForm(u string, params url.Values) (*http.Response, error) {
+	c.url = u
+	c.params = params
+	return &http.Response{
+		Body: io.NopCloser(bytes.NewBufferString("access_token=123abc")),
+		Header: http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		},
+		StatusCode: 200,
+	}, nil
+}
+
+func TestFromFormPoster(t *testing.T) {
+	poster := &formPoster{}
+	resp, err := PostForm(context.Background(), FromFormPoster(poster), "https://github.com/oauth",
+		url.Values{"client_id": {"CLIENT-ID"}, "scope": {"repo gist"}}, RequestOptions{})
+	if err != nil {
+		t.Fatalf("PostForm() error: %v", err)
+	}
+	if resp.Get("access_token") != "123abc" {
+		t.Errorf("access_token = %q", resp.Get("access_token"))
+	}
+	if poster.url != "https://github.com/oauth" {
+		t.Errorf("url = %q", poster.url)
+	}
+	if want := (url.Values{"client_id": {"CLIENT-ID"}, "scope": {"repo gist"}}); !reflect.DeepEqual(poster.params, want) {
+		t.Errorf("params = %v, want %v", poster.params, want)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://github.com/oauth", nil)
+	if _, err := FromFormPoster(poster).Do(req); err == nil {
+		t.Error("expected error for GET request")
+	}
+}
+
+func TestPostForm_maxBodySize(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "urlencoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "access_token=" + strings.Repeat("a", 100),
+		},
+		{
+			name:        "JSON",
+			contentType: "application/json",
+			body:        `{"access_token":"` + strings.Repeat("a", 100) + `"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &apiClient{status: 200, body: tt.body, contentType: tt.contentType}
+			_, err := PostForm(context.Background(), client, "https://github.com/oauth", nil, RequestOptions{MaxBodySize: 64})
+			if !errors.Is(err, ErrResponseTooLarge) {
+				t.Fatalf("PostForm() error = %v, want ErrResponseTooLarge", err)
+			}
+
+			client = &apiClient{status: 200, body: tt.body, contentType: tt.contentType}
+			resp, err := PostForm(context.Background(), client, "https://github.com/oauth", nil, RequestOptions{MaxBodySize: 256})
+			if err != nil {
+				t.Fatalf("PostForm() error: %v", err)
+			}
+			if len(resp.Get("access_token")) != 100 {
+				t.Errorf("access_token = %q", resp.Get("access_token"))
+			}
+		})
+	}
+}
+
+func TestPostForm_unexpectedContentType(t *testing.T) {
+	page := "<!DOCTYPE html><html><head><title>Unicorn!</title><style>body { color: red }</style></head>" +
+		"<body><h1>No server is currently available to service your request.</h1>" +
+		strings.Repeat("<p>padding</p>", 1000) + "</body></html>"
+	client := &apiClient{status: 503, body: page, contentType: "text/html; charset=utf-8"}
+
+	resp, err := PostForm(context.Background(), client, "https://github.com/login/oauth/access_token", nil, RequestOptions{})
+	if err != nil {
+		t.Fatalf("PostForm() error: %v", err)
+	}
+
+	err = resp.Err()
+	var ctErr *ContentTypeError
+	if !errors.As(err, &ctErr) {
+		t.Fatalf("Err() = %v, want a *ContentTypeError cause", err)
+	}
+	if len(ctErr.Snippet) != maxSnippetSize {
+		t.Errorf("len(Snippet) = %d, want %d", len(ctErr.Snippet), maxSnippetSize)
+	}
+	want := "HTTP 503: server responded with text/html; charset=utf-8 instead of a form or JSON: " +
+		"Unicorn! No server is currently available to service your request. padding padding padding padding padding padding paddi..."
+	if err.Error() != want {
+		t.Errorf("Err() = %q, want %q", err.Error(), want)
+	}
+}
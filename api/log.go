@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// secretParams are the OAuth parameters whose values must never appear in logs.
+var secretParams = []string{
+	"access_token",
+	"refresh_token",
+	"device_code",
+	"code",
+	"client_secret",
+	"code_verifier",
+}
+
+var (
+	secretParamRE = regexp.MustCompile(`(?i)\b(` + strings.Join(secretParams, "|") + `)=[^&\s"']*`)
+	secretJSONRE  = regexp.MustCompile(`(?i)"(` + strings.Join(secretParams, "|") + `)"\s*:\s*"[^"]*"`)
+)
+
+func isSecretParam(name string) bool {
+	for _, p := range secretParams {
+		if strings.EqualFold(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactLogger returns a logger that strips the values of secret OAuth parameters, such as access
+// tokens and authorization codes, from every record before passing it on to the handler of l. Values
+// of types that it does not know how to inspect are replaced as a whole, unless they implement
+// slog.LogValuer. If l is nil, the returned logger discards all records.
+func RedactLogger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.New(discardHandler{})
+	}
+	if _, ok := l.Handler().(*redactHandler); ok {
+		return l
+	}
+	return slog.New(&redactHandler{next: l.Handler()})
+}
+
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, nr)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(redactedAttrs)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if isSecretParam(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, ga := range group {
+			attrs[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		return slog.Any(a.Key, redactAny(v.Any()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func redactAny(v any) any {
+	switch v := v.(type) {
+	case url.Values:
+		return redactValues(v)
+	case http.Header:
+		h := v.Clone()
+		for k := range h {
+			if strings.EqualFold(k, "Authorization") || isSecretParam(k) {
+				h[k] = []string{redacted}
+			}
+		}
+		return h
+	case map[string]string:
+		m := make(map[string]string, len(v))
+		for k, s := range v {
+			if isSecretParam(k) {
+				s = redacted
+			}
+			m[k] = redactString(s)
+		}
+		return m
+	case []string:
+		r := make([]string, len(v))
+		for i, s := range v {
+			r[i] = redactString(s)
+		}
+		return r
+	case error:
+		if s := v.Error(); redactString(s) != s {
+			return errors.New(redactString(s))
+		}
+		return v
+	case nil:
+		return nil
+	}
+
+	// Other values, such as structs holding tokens, can't be inspected reliably: their formatting may
+	// reveal secrets without matching any of the patterns above.
+	return redacted
+}
+
+func redactValues(values url.Values) url.Values {
+	if values == nil {
+		return nil
+	}
+	r := make(url.Values, len(values))
+	for k, vs := range values {
+		if isSecretParam(k) {
+			r[k] = []string{redacted}
+			continue
+		}
+		rvs := make([]string, len(vs))
+		for i, s := range vs {
+			rvs[i] = redactString(s)
+		}
+		r[k] = rvs
+	}
+	return r
+}
+
+// redactString replaces secret values embedded in free-form text, such as URLs with query strings,
+// form-encoded bodies and JSON documents.
+func redactString(s string) string {
+	s = secretParamRE.ReplaceAllString(s, "$1="+redacted)
+	return secretJSONRE.ReplaceAllString(s, `"$1":"`+redacted+`"`)
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// LoggingClient wraps an HTTP client to log every request made through it, such as those made by
+// PostForm. Secret parameter values are redacted from the logs.
+type LoggingClient struct {
+	// Client makes the actual requests.
+	Client Doer
+	// Logger receives a debug record for every exchange.
+	Logger *slog.Logger
+}
+
+// Do makes the request using the wrapped client and logs its outcome.
+func (c *LoggingClient) Do(req *http.Request) (*http.Response, error) {
+	logger := RedactLogger(c.Logger)
+	attrs := []any{"method", req.Method, "url", req.URL.String()}
+	if params := requestParams(req); params != nil {
+		attrs = append(attrs, "params", params)
+	}
+
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		logger.Debug("HTTP request failed", append(attrs, "error", err, "duration", time.Since(start))...)
+		return resp, err
+	}
+	logger.Debug("HTTP exchange", append(attrs,
+		"status", resp.StatusCode, "content_type", resp.Header.Get("Content-Type"),
+		"duration", time.Since(start))...)
+	return resp, nil
+}
+
+// requestParams returns the form parameters of req without consuming its body, or nil if the body
+// is not a form.
+func requestParams(req *http.Request) url.Values {
+	if req.GetBody == nil || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil
+	}
+	params, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil
+	}
+	return params
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*slog.Logger)
+		want string
+	}{
+		{
+			name: "secret attribute",
+			log: func(l *slog.Logger) {
+				l.Info("token", "access_token", "gho_SEKRIT", "scope", "repo")
+			},
+			want: `level=INFO msg=token access_token=[REDACTED] scope=repo`,
+		},
+		{
+			name: "form values",
+			log: func(l *slog.Logger) {
+				l.Info("post", "params", url.Values{
+					"client_id":     {"CLIENT-ID"},
+					"client_secret": {"SEKRIT"},
+					"code":          {"ABC-123"},
+				})
+			},
+			want: `level=INFO msg=post params="map[client_id:[CLIENT-ID] client_secret:[[REDACTED]] code:[[REDACTED]]]"`,
+		},
+		{
+			name: "URL with query",
+			log: func(l *slog.Logger) {
+				l.Info("callback", "url", "/callback?code=ABC-123&state=xyz")
+			},
+			want: `level=INFO msg=callback url="/callback?code=[REDACTED]&state=xyz"`,
+		},
+		{
+			name: "JSON text",
+			log: func(l *slog.Logger) {
+				l.Info("body", "body", `{"refresh_token": "ghr_SEKRIT", "token_type": "bearer"}`)
+			},
+			want: `level=INFO msg=body body="{\"refresh_token\":\"[REDACTED]\", \"token_type\": \"bearer\"}"`,
+		},
+		{
+			name: "error",
+			log: func(l *slog.Logger) {
+				l.Info("failed", "error", errors.New(`Post "https://github.com/token?device_code=DEVIC": EOF`))
+			},
+			want: `level=INFO msg=failed error="Post \"https://github.com/token?device_code=[REDACTED]\": EOF"`,
+		},
+		{
+			name: "group",
+			log: func(l *slog.Logger) {
+				l.Info("grouped", slog.Group("resp", "code_verifier", "VERIFIER", "status", 200))
+			},
+			want: `level=INFO msg=grouped resp.code_verifier=[REDACTED] resp.status=200`,
+		},
+		{
+			name: "preset attributes",
+			log: func(l *slog.Logger) {
+				l.With("client_secret", "SEKRIT").Info("hello")
+			},
+			want: `level=INFO msg=hello client_secret=[REDACTED]`,
+		},
+		{
+			name: "access token value",
+			log: func(l *slog.Logger) {
+				l.Info("granted", "token", &AccessToken{Token: "gho_SEKRIT", Type: "bearer", Scope: "repo"})
+			},
+			want: `level=INFO msg=granted token.type=bearer token.scope=repo token.refreshable=false`,
+		},
+		{
+			name: "access token in a struct",
+			log: func(l *slog.Logger) {
+				l.Info("granted", "result", struct{ Token *AccessToken }{&AccessToken{Token: "gho_SEKRIT", RefreshToken: "ghr_SEKRIT"}})
+			},
+			want: `level=INFO msg=granted result=[REDACTED]`,
+		},
+		{
+			name: "string slice",
+			log: func(l *slog.Logger) {
+				l.Info("redirect", "urls", []string{"/callback?code=ABC-123", "/done"})
+			},
+			want: `level=INFO msg=redirect urls="[/callback?code=[REDACTED] /done]"`,
+		},
+		{
+			name: "user code is not a secret",
+			log: func(l *slog.Logger) {
+				l.Info("code", "params", "user_code=ABCD-1234")
+			},
+			want: `level=INFO msg=code params="user_code=ABCD-1234"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			tt.log(RedactLogger(newTestLogger(buf)))
+			if got := strings.TrimSpace(buf.String()); got != tt.want {
+				t.Errorf("logged:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactLogger_nil(t *testing.T) {
+	l := RedactLogger(nil)
+	if l == nil {
+		t.Fatal("RedactLogger(nil) = nil")
+	}
+	l.Info("discarded", "access_token", "SEKRIT")
+}
+
+func TestLoggingClient(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &LoggingClient{
+		Client: &apiClient{
+			status:      200,
+			body:        "access_token=SEKRIT",
+			contentType: "application/x-www-form-urlencoded",
+		},
+		Logger: newTestLogger(buf),
+	}
+
+	resp, err := PostForm(context.Background(), c, "https://github.com/token", url.Values{
+		"client_id": {"CLIENT-ID"},
+		"code":      {"ABC-123"},
+	}, RequestOptions{})
+	if err != nil {
+		t.Fatalf("PostForm() error: %v", err)
+	}
+	if resp.Get("access_token") != "SEKRIT" {
+		t.Errorf("access_token = %q", resp.Get("access_token"))
+	}
+
+	logged := buf.String()
+	if strings.Contains(logged, "ABC-123") || strings.Contains(logged, "SEKRIT") {
+		t.Errorf("secret leaked into log: %s", logged)
+	}
+	for _, want := range []string{"url=https://github.com/token", "status=200", "client_id:[CLIENT-ID]"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log %q does not contain %q", logged, want)
+		}
+	}
+}
+
+func TestLoggingClient_error(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &LoggingClient{
+		Client: failingClient{err: errors.New("dial tcp: connection refused")},
+		Logger: newTestLogger(buf),
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://github.com/token", nil)
+	_, err := c.Do(req)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(buf.String(), `error="dial tcp: connection refused"`) {
+		t.Errorf("logged: %s", buf.String())
+	}
+}
+
+type failingClient struct {
+	err error
+}
+
+func (c failingClient) Do(*http.Request) (*http.Response, error) {
+	return nil, c.err
+}
+
+func newTestLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
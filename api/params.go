@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthRequestEditorFn defines the function signature for setting additional form values, such as
+// provider-specific parameters, on authorization and token requests.
+type AuthRequestEditorFn func(*url.Values)
+
+// WithAudience sets the audience parameter in the request.
+func WithAudience(audience string) AuthRequestEditorFn {
+	return func(values *url.Values) {
+		if audience != "" {
+			values.Add("audience", audience)
+		}
+	}
+}
+
+// WithParam sets the parameter named key to value in the request, replacing any previous value.
+func WithParam(key, value string) AuthRequestEditorFn {
+	return func(values *url.Values) {
+		values.Set(key, value)
+	}
+}
+
+// OIDCParams are the standard OpenID Connect parameters of an authorization request. Empty fields
+// are not sent.
+type OIDCParams struct {
+	// Prompt asks the server to prompt the user for reauthentication ("login"), consent ("consent"),
+	// account selection ("select_account") or not at all ("none").
+	Prompt string
+	// LoginHint suggests the login identifier, such as an email address, to prefill.
+	LoginHint string
+	// MaxAge is the maximum time since the user last authenticated actively; if exceeded, the server
+	// must prompt them again. Sent with second precision.
+	MaxAge time.Duration
+	// UILocales are the preferred languages for the user interface as BCP47 tags, e.g. "de-CH".
+	UILocales []string
+	// ACRValues are the requested authentication context class references, in order of preference.
+	ACRValues []string
+	// Claims is a JSON object requesting individual claims.
+	Claims string
+	// Nonce is a value that the server includes in the ID token, to tie it to this request.
+	Nonce string
+}
+
+// WithOIDCParams sets the parameters of p that are not empty in the request.
+func WithOIDCParams(p OIDCParams) AuthRequestEditorFn {
+	return func(values *url.Values) {
+		set := func(key, value string) {
+			if value != "" {
+				values.Set(key, value)
+			}
+		}
+		set("prompt", p.Prompt)
+		set("login_hint", p.LoginHint)
+		if p.MaxAge > 0 {
+			set("max_age", strconv.FormatInt(int64(p.MaxAge/time.Second), 10))
+		}
+		set("ui_locales", strings.Join(p.UILocales, " "))
+		set("acr_values", strings.Join(p.ACRValues, " "))
+		set("claims", p.Claims)
+		set("nonce", p.Nonce)
+	}
+}
+
+// EditParams applies each of editors to values in order.
+func EditParams(values url.Values, editors ...AuthRequestEditorFn) {
+	for _, fn := range editors {
+		fn(&values)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEditParams(t *testing.T) {
+	values := url.Values{"scope": {"repo"}}
+	EditParams(values,
+		WithAudience(""),
+		WithAudience("https://api.github.com"),
+		WithParam("prompt", "login"),
+		WithParam("scope", "read:org"),
+	)
+
+	if got, want := values.Encode(), "audience=https%3A%2F%2Fapi.github.com&prompt=login&scope=read%3Aorg"; got != want {
+		t.Errorf("EditParams() = %q, want %q", got, want)
+	}
+}
+
+func TestWithOIDCParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params OIDCParams
+		want   string
+	}{
+		{
+			name: "empty",
+			want: "scope=openid",
+		},
+		{
+			name: "all",
+			params: OIDCParams{
+				Prompt:    "login",
+				LoginHint: "monalisa@example.com",
+				MaxAge:    90 * time.Second,
+				UILocales: []string{"de-CH", "en"},
+				ACRValues: []string{"phr", "phrh"},
+				Claims:    `{"id_token":{"email":null}}`,
+				Nonce:     "NONCE",
+			},
+			want: "acr_values=phr+phrh&claims=%7B%22id_token%22%3A%7B%22email%22%3Anull%7D%7D&login_hint=monalisa%40example.com&max_age=90&nonce=NONCE&prompt=login&scope=openid&ui_locales=de-CH+en",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := url.Values{"scope": {"openid"}}
+			EditParams(values, WithOIDCParams(tt.params))
+			if got := values.Encode(); got != tt.want {
+				t.Errorf("params = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
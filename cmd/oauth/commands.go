@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/dockercredential"
+	"github.com/cli/oauth/gitcredential"
+	"github.com/cli/oauth/kubecredential"
+)
+
+func runLogin(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	web := fs.Bool("web", false, "skip Device flow and log in with the web application flow")
+	s, opts, err := a.parse("login", fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if s.ClientID == "" {
+		return fmt.Errorf("no client ID for %s; use -client-id, OAUTH_CLIENT_ID or the config file", s.hostName)
+	}
+
+	m := a.manager(s)
+	flow := a.flow(s, opts)
+	if !*web {
+		account, err := m.Login(ctx, s.hostName, flow)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.stderr, "Logged in to %s as %s\n", account.Host, account.Login)
+		return nil
+	}
+
+	token, err := flow.WebAppFlowContext(ctx)
+	if err != nil {
+		return err
+	}
+	account, err := m.Add(ctx, s.hostName, token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "Logged in to %s as %s\n", account.Host, account.Login)
+	return nil
+}
+
+func runLogout(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ContinueOnError)
+	user := fs.String("user", "", "the `login` of the account to log out of, instead of the active one")
+	s, opts, err := a.parse("logout", fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	m := a.manager(s)
+	entry, err := m.Active(s.hostName)
+	if *user != "" {
+		entry, err = m.Get(s.hostName, *user)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The account is removed even if the server can not be told to revoke its token.
+	flow := a.flow(s, opts)
+	var tokens []string
+	if entry.Token != nil {
+		tokens = []string{entry.Token.RefreshToken, entry.Token.Token}
+	}
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		err := flow.RevokeContext(ctx, token)
+		if errors.Is(err, oauth.ErrRevocationUnsupported) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(a.stderr, "warning: could not revoke the token: %v\n", err)
+		}
+	}
+
+	if err := m.Remove(entry.Account.Host, entry.Account.Login); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "Logged out of %s account %s\n", entry.Account.Host, entry.Account.Login)
+	return nil
+}
+
+func runStatus(_ context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	s, _, err := a.parse("status", fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	// Unless a host is set, all hosts are listed.
+	var host string
+	if s.hostSet {
+		host = s.hostName
+	}
+	m := a.manager(s)
+	list, err := m.List(host)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		if host != "" {
+			return fmt.Errorf("not logged in to %s", host)
+		}
+		return errors.New("not logged in to any host")
+	}
+
+	for i, account := range list {
+		if i == 0 || list[i-1].Host != account.Host {
+			fmt.Fprintln(a.stdout, account.Host)
+		}
+		entry, err := m.Get(account.Host, account.Login)
+		if err != nil {
+			return err
+		}
+		active := ""
+		if account.Active {
+			active = " (active)"
+		}
+		fmt.Fprintf(a.stdout, "  %s%s: scopes %s%s\n", account.Login, active, tokenScope(entry.Token), describeExpiry(entry))
+	}
+	return nil
+}
+
+func runToken(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	s, opts, err := a.parse("token", fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.manager(s).Token(ctx, s.hostName, a.flow(s, opts))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.stdout, token.Token)
+	return nil
+}
+
+func runRefresh(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	s, opts, err := a.parse("refresh", fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	entry, err := a.manager(s).Refresh(ctx, s.hostName, a.flow(s, opts))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "Refreshed the token of %s on %s%s\n", entry.Account.Login, entry.Account.Host, describeExpiry(entry))
+	return nil
+}
+
+func runGitCredential(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("git-credential", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oauth git-credential [flags] get|store|erase")
+		fs.PrintDefaults()
+	}
+	allowHTTP := fs.Bool("allow-http", false, "also provide tokens for remotes over plain HTTP, which sends them unencrypted")
+	s, opts, err := a.parse("git-credential", fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	h := &gitcredential.Helper{
+		Manager: a.manager(s),
+		Flow: func(c *gitcredential.Credential) (*oauth.Flow, error) {
+			hostOpts := *opts
+			if hostOpts.host == "" {
+				hostOpts.host = c.Host
+			}
+			hs, err := hostOpts.resolve(a.getenv)
+			if err != nil || hs.ClientID == "" {
+				// Hosts without a client ID are left to other helpers.
+				return nil, err
+			}
+			return a.flow(hs, opts), nil
+		},
+		Interactive: a.getenv("GIT_TERMINAL_PROMPT") != "0",
+		AllowHTTP:   *allowHTTP,
+		Stderr:      a.stderr,
+	}
+	return h.Run(ctx, fs.Arg(0), a.stdin, a.stdout)
+}
+
+func runKubeCredential(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("kube-credential", flag.ContinueOnError)
+	s, opts, err := a.parse("kube-credential", fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	p := &kubecredential.Plugin{
+		Manager: a.manager(s),
+		Host:    s.hostName,
+		Flow:    a.flow(s, opts),
+		Getenv:  a.getenv,
+		Stderr:  a.stderr,
+	}
+	return p.Run(ctx, a.stdout)
+}
+
+func runDockerCredential(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("docker-credential", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oauth docker-credential [flags] get|store|erase|list")
+		fs.PrintDefaults()
+	}
+	registries := fs.String("registries", "", "comma-separated container `registries` that accept the tokens of the host")
+	s, opts, err := a.parse("docker-credential", fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	serverURLs := s.Registries
+	if *registries != "" {
+		serverURLs = strings.Split(*registries, ",")
+	}
+	if len(serverURLs) == 0 {
+		serverURLs = defaultRegistries(s.hostName)
+	}
+
+	flow := a.flow(s, opts)
+	h := &dockercredential.Helper{
+		Manager: a.manager(s),
+		// Docker has no way to let the user interact, but the instructions can be shown on a terminal.
+		Interactive: isTerminal(a.stderr),
+		Stderr:      a.stderr,
+	}
+	for _, serverURL := range serverURLs {
+		h.Registries = append(h.Registries, dockercredential.Registry{
+			ServerURL: strings.TrimSpace(serverURL),
+			Host:      s.hostName,
+			Flow:      flow,
+		})
+	}
+
+	if err := h.Run(ctx, fs.Arg(0), a.stdin, a.stdout); err != nil {
+		// The helper has written the error to stdout, where Docker expects it.
+		return errReported
+	}
+	return nil
+}
+
+// defaultRegistries returns the container registries of a GitHub host.
+func defaultRegistries(host string) []string {
+	if host == defaultHost {
+		return []string{"ghcr.io", "docker.pkg.github.com"}
+	}
+	return []string{"containers." + host}
+}
+
+// isTerminal reports whether w writes to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
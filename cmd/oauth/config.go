@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cli/oauth"
+)
+
+const defaultHost = "github.com"
+
+// fileConfig is the content of the config file.
+type fileConfig struct {
+	// DefaultHost is the host to use when none is given with -host or OAUTH_HOST.
+	DefaultHost string `json:"default_host"`
+	// Store is the path of the file that tokens are kept in.
+	Store string `json:"store"`
+	// Hosts holds the settings for each host, keyed by host name.
+	Hosts map[string]hostConfig `json:"hosts"`
+}
+
+// hostConfig holds the settings to log in to a host with.
+type hostConfig struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+	CallbackURI  string   `json:"callback_uri"`
+
+	// URL is the base URL of a GitHub instance that the endpoints are derived from. Defaults to
+	// "https://" followed by the host name.
+	URL string `json:"url"`
+	// The endpoints override those derived from URL, for servers other than GitHub.
+	DeviceCodeURL string `json:"device_code_url"`
+	AuthorizeURL  string `json:"authorize_url"`
+	TokenURL      string `json:"token_url"`
+	RevocationURL string `json:"revocation_url"`
+
+	// Registries are the container registries that accept the tokens of the host, for the
+	// docker-credential command.
+	Registries []string `json:"registries"`
+}
+
+// options are the settings shared by all commands, as given with flags.
+type options struct {
+	configPath   string
+	storePath    string
+	host         string
+	clientID     string
+	clientSecret string
+	scopes       string
+	debug        bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configPath, "config", "", "path of the config `file` (env OAUTH_CONFIG)")
+	fs.StringVar(&o.storePath, "store", "", "path of the `file` that tokens are kept in (env OAUTH_STORE)")
+	fs.StringVar(&o.host, "host", "", "the `host` to authorize with (env OAUTH_HOST, default \"github.com\")")
+	fs.StringVar(&o.clientID, "client-id", "", "the OAuth app client `ID` (env OAUTH_CLIENT_ID)")
+	fs.StringVar(&o.clientSecret, "client-secret", "", "the OAuth app client `secret` (env OAUTH_CLIENT_SECRET)")
+	fs.StringVar(&o.scopes, "scopes", "", "comma-separated `scopes` to request (env OAUTH_SCOPES)")
+	fs.BoolVar(&o.debug, "debug", false, "log HTTP requests and flow decisions to stderr")
+}
+
+// settings are the resolved settings for a command.
+type settings struct {
+	storePath string
+	hostName  string
+	// hostSet reports whether hostName was chosen with -host, OAUTH_HOST or the config file rather
+	// than defaulted.
+	hostSet bool
+	host    *oauth.Host
+	hostConfig
+}
+
+// resolve combines the flags, the environment variables looked up with getenv and the config file,
+// in that order of precedence.
+func (o *options) resolve(getenv func(string) string) (*settings, error) {
+	configPath := firstNonEmpty(o.configPath, getenv("OAUTH_CONFIG"))
+	explicitConfig := configPath != ""
+	if !explicitConfig {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		configPath = filepath.Join(dir, "oauth", "config.json")
+	}
+	cfg, err := loadConfig(configPath)
+	if errors.Is(err, fs.ErrNotExist) && !explicitConfig {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the config file: %w", err)
+	}
+
+	hostName := firstNonEmpty(o.host, getenv("OAUTH_HOST"), cfg.DefaultHost)
+	s := &settings{
+		storePath: firstNonEmpty(o.storePath, getenv("OAUTH_STORE"), cfg.Store),
+		hostName:  strings.ToLower(firstNonEmpty(hostName, defaultHost)),
+		hostSet:   hostName != "",
+	}
+	if s.storePath == "" {
+		s.storePath = filepath.Join(filepath.Dir(configPath), "accounts.json")
+	}
+
+	s.hostConfig = cfg.Hosts[s.hostName]
+	s.ClientID = firstNonEmpty(o.clientID, getenv("OAUTH_CLIENT_ID"), s.ClientID)
+	s.ClientSecret = firstNonEmpty(o.clientSecret, getenv("OAUTH_CLIENT_SECRET"), s.ClientSecret)
+	if scopes := firstNonEmpty(o.scopes, getenv("OAUTH_SCOPES")); scopes != "" {
+		s.Scopes = strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	if s.CallbackURI == "" {
+		s.CallbackURI = "http://127.0.0.1/callback"
+	}
+
+	s.host, err = oauth.NewGitHubHost(firstNonEmpty(s.URL, "https://"+s.hostName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL for %s: %w", s.hostName, err)
+	}
+	s.host.DeviceCodeURL = firstNonEmpty(s.DeviceCodeURL, s.host.DeviceCodeURL)
+	s.host.AuthorizeURL = firstNonEmpty(s.AuthorizeURL, s.host.AuthorizeURL)
+	s.host.TokenURL = firstNonEmpty(s.TokenURL, s.host.TokenURL)
+	s.host.RevocationURL = s.RevocationURL
+	return s, nil
+}
+
+func loadConfig(path string) (fileConfig, error) {
+	var cfg fileConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,259 @@
+// Command oauth logs in to GitHub hosts, or other OAuth servers, and manages the resulting access
+// tokens of several accounts per host.
+//
+// Usage:
+//
+//	oauth <command> [flags]
+//
+// The commands are:
+//
+//	login              log in to a host and make the account the active one
+//	logout             log out of the active account, or of the one given with -user
+//	status             list the accounts that are logged in to the host, or to all hosts if none is set
+//	token              print the access token of the active account, refreshing it if needed
+//	refresh            renew the access token of the active account
+//	git-credential     act as a git credential helper
+//	kube-credential    act as a Kubernetes client-go credential plugin
+//	docker-credential  act as a Docker credential helper
+//
+// Settings are taken from flags, then from the environment variables OAUTH_HOST, OAUTH_CLIENT_ID,
+// OAUTH_CLIENT_SECRET, OAUTH_SCOPES, OAUTH_STORE and OAUTH_CONFIG, and finally from the JSON config
+// file, which defaults to oauth/config.json in the user config directory:
+//
+//	{
+//	  "default_host": "ghe.example.com",
+//	  "hosts": {
+//	    "ghe.example.com": {
+//	      "client_id": "...",
+//	      "client_secret": "...",
+//	      "scopes": ["repo", "read:org"]
+//	    }
+//	  }
+//	}
+//
+// A host can also set "url" to the base URL of a GitHub instance, or "device_code_url",
+// "authorize_url", "token_url" and "revocation_url" for other OAuth servers. Tokens are kept in
+// accounts.json next to the config file unless "store" or -store says otherwise.
+//
+// To provide the tokens to git over HTTPS, configure the command as a credential helper:
+//
+//	git config --global credential.https://ghe.example.com.helper "!oauth git-credential"
+//
+// git then runs "oauth git-credential get" and so on. The host of each request selects the host
+// settings, unless -host is given. Logging in is allowed unless GIT_TERMINAL_PROMPT is 0.
+//
+// To authenticate to Kubernetes clusters with the tokens, configure the command as the exec
+// credential plugin of a kubeconfig user:
+//
+//	users:
+//	- name: github
+//	  user:
+//	    exec:
+//	      apiVersion: client.authentication.k8s.io/v1
+//	      command: oauth
+//	      args: ["kube-credential", "-host", "github.com"]
+//	      interactiveMode: IfAvailable
+//
+// Logging in is allowed only if kubectl says that the user can interact with the command.
+//
+// To provide the tokens to Docker for container registries, install the command under the name
+// docker-credential-oauth, e.g. as a symbolic link, and configure it in ~/.docker/config.json:
+//
+//	{
+//	  "credHelpers": {
+//	    "ghcr.io": "oauth"
+//	  }
+//	}
+//
+// Invoked under that name, the command acts as "oauth docker-credential". The registries of a host
+// are ghcr.io and docker.pkg.github.com for github.com, and containers.<host> for other hosts, unless
+// configured with -registries or "registries" in the host settings. Logging in is allowed if stderr
+// is a terminal. "docker logout" does not log out of the account, which git and kubectl may share;
+// use "oauth logout" for that.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/api"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a := &app{
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		getenv:   os.Getenv,
+		identify: accounts.GitHubIdentity(http.DefaultClient),
+	}
+	args := os.Args[1:]
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "docker-credential-") {
+		args = append([]string{"docker-credential"}, args...)
+	}
+	code := a.run(ctx, args)
+	stop()
+	os.Exit(code)
+}
+
+// app runs the commands. Its fields are the dependencies on the environment, which tests substitute.
+type app struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	// store keeps the tokens. Optional: defaults to an accounts.FileStore at the configured path.
+	store accounts.Store
+	// identify finds out which account a token belongs to.
+	identify accounts.IdentityFunc
+	// browseURL opens a web browser. Optional: defaults to the system browser.
+	browseURL func(string) error
+}
+
+var (
+	// errUsage is returned for invalid command lines, after the usage has been printed.
+	errUsage = errors.New("usage")
+	// errReported is returned by commands that have reported their error in the format that their
+	// caller expects.
+	errReported = errors.New("reported")
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"login", "log in to a host and make the account the active one", runLogin},
+	{"logout", "log out of the active account, or of the one given with -user", runLogout},
+	{"status", "list the accounts that are logged in", runStatus},
+	{"token", "print the access token of the active account, refreshing it if needed", runToken},
+	{"refresh", "renew the access token of the active account", runRefresh},
+	{"git-credential", "act as a git credential helper", runGitCredential},
+	{"kube-credential", "act as a Kubernetes client-go credential plugin", runKubeCredential},
+	{"docker-credential", "act as a Docker credential helper", runDockerCredential},
+}
+
+// run executes the command line args and returns the exit code.
+func (a *app) run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		a.usage()
+		return 2
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(ctx, a, args[1:])
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, errUsage):
+			return 2
+		case errors.Is(err, errReported):
+			return 1
+		default:
+			fmt.Fprintf(a.stderr, "oauth %s: %v\n", cmd.name, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(a.stderr, "oauth: unknown command %q\n", args[0])
+	a.usage()
+	return 2
+}
+
+func (a *app) usage() {
+	fmt.Fprintln(a.stderr, "Usage: oauth <command> [flags]")
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(a.stderr, "  %-18s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, `Run "oauth <command> -h" for the flags of a command.`)
+}
+
+// parse parses the flags of the command name, including those shared by all commands, and resolves
+// the settings. The command takes nargs arguments after the flags.
+func (a *app) parse(name string, fs *flag.FlagSet, args []string, nargs int) (*settings, *options, error) {
+	var opts options
+	opts.register(fs)
+	fs.SetOutput(a.stderr)
+	if err := fs.Parse(args); err != nil {
+		// The flag package has printed the error along with the usage.
+		return nil, nil, errUsage
+	}
+	if fs.NArg() > nargs {
+		fmt.Fprintf(a.stderr, "oauth %s: unexpected argument %q\n", name, fs.Arg(nargs))
+		fs.Usage()
+		return nil, nil, errUsage
+	}
+	if fs.NArg() < nargs {
+		fmt.Fprintf(a.stderr, "oauth %s: missing argument\n", name)
+		fs.Usage()
+		return nil, nil, errUsage
+	}
+	s, err := opts.resolve(a.getenv)
+	return s, &opts, err
+}
+
+// manager returns the account manager for the settings.
+func (a *app) manager(s *settings) *accounts.Manager {
+	store := a.store
+	if store == nil {
+		store = &accounts.FileStore{Path: s.storePath}
+	}
+	return accounts.NewManager(store, a.identify)
+}
+
+// flow returns the OAuth flow for the settings. UI messages go to stderr, so that the output of
+// commands such as token can be captured.
+func (a *app) flow(s *settings, opts *options) *oauth.Flow {
+	flow := &oauth.Flow{
+		Host:         s.host,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Scopes:       s.Scopes,
+		CallbackURI:  s.CallbackURI,
+		BrowseURL:    a.browseURL,
+		Stdin:        a.stdin,
+		Stdout:       a.stderr,
+	}
+	if opts.debug {
+		flow.Logger = slog.New(slog.NewTextHandler(a.stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	return flow
+}
+
+// describeExpiry returns a note on when the token of e expires, if it does.
+func describeExpiry(e accounts.Entry) string {
+	expiresAt := e.ExpiresAt()
+	if expiresAt.IsZero() {
+		return ""
+	}
+	return ", token expires " + expiresAt.Local().Format("2006-01-02 15:04 MST")
+}
+
+// tokenScope returns the scopes of token for display.
+func tokenScope(token *api.AccessToken) string {
+	if token == nil || token.Scope == "" {
+		return "none"
+	}
+	return token.Scope
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+type testApp struct {
+	*app
+	stdout, stderr *bytes.Buffer
+}
+
+func newTestApp(t *testing.T, env map[string]string) *testApp {
+	t.Helper()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	return &testApp{
+		app: &app{
+			stdin:  strings.NewReader("\n"),
+			stdout: stdout,
+			stderr: stderr,
+			getenv: func(k string) string { return env[k] },
+			store:  &accounts.MemoryStore{},
+			identify: func(context.Context, string, *api.AccessToken) (string, string, error) {
+				return "monalisa", "1", nil
+			},
+			browseURL: func(string) error { return nil },
+		},
+		stdout: stdout,
+		stderr: stderr,
+	}
+}
+
+func (a *testApp) exec(args ...string) int {
+	a.stdout.Reset()
+	a.stderr.Reset()
+	return a.run(context.Background(), args)
+}
+
+func writeConfig(t *testing.T, cfg fileConfig) string {
+	t.Helper()
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApp(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID", TokenExpiresIn: 3600})
+	defer s.Close()
+
+	config := writeConfig(t, fileConfig{
+		DefaultHost: "ghe.example.com",
+		Hosts: map[string]hostConfig{
+			"ghe.example.com": {
+				ClientID:      "CLIENT-ID",
+				Scopes:        []string{"repo"},
+				URL:           s.URL,
+				RevocationURL: s.RevocationURL(),
+			},
+		},
+	})
+	a := newTestApp(t, map[string]string{"OAUTH_CONFIG": config})
+
+	if code := a.exec("login"); code != 0 {
+		t.Fatalf("login: exit code %d: %s", code, a.stderr)
+	}
+	if !strings.Contains(a.stderr.String(), "Logged in to ghe.example.com as monalisa") {
+		t.Errorf("login: stderr = %q", a.stderr)
+	}
+
+	if code := a.exec("status"); code != 0 {
+		t.Fatalf("status: exit code %d: %s", code, a.stderr)
+	}
+	if !strings.HasPrefix(a.stdout.String(), "ghe.example.com\n  monalisa (active): scopes repo, token expires ") {
+		t.Errorf("status: stdout = %q", a.stdout)
+	}
+
+	if code := a.exec("token"); code != 0 {
+		t.Fatalf("token: exit code %d: %s", code, a.stderr)
+	}
+	first := strings.TrimSpace(a.stdout.String())
+	if !s.Valid(first) {
+		t.Errorf("token: %q was not issued by the server", first)
+	}
+
+	if code := a.exec("refresh"); code != 0 {
+		t.Fatalf("refresh: exit code %d: %s", code, a.stderr)
+	}
+	if code := a.exec("token"); code != 0 {
+		t.Fatalf("token: exit code %d: %s", code, a.stderr)
+	}
+	refreshed := strings.TrimSpace(a.stdout.String())
+	if refreshed == first || !s.Valid(refreshed) {
+		t.Errorf("token after refresh = %q", refreshed)
+	}
+
+	if code := a.exec("logout"); code != 0 {
+		t.Fatalf("logout: exit code %d: %s", code, a.stderr)
+	}
+	if s.Valid(refreshed) {
+		t.Error("logout did not revoke the token")
+	}
+	if code := a.exec("status"); code != 1 || a.stderr.String() != "oauth status: not logged in to ghe.example.com\n" {
+		t.Errorf("status after logout: exit code %d: %s", code, a.stderr)
+	}
+}
+
+func TestApp_status(t *testing.T) {
+	entries := []accounts.Entry{
+		{
+			Account: accounts.Account{Host: "ghe.example.com", Login: "hubot", Active: true},
+			Token:   &api.AccessToken{Token: "TOKEN-1", Scope: "repo"},
+		},
+		{
+			Account: accounts.Account{Host: "github.com", Login: "monalisa", Active: true},
+			Token:   &api.AccessToken{Token: "TOKEN-2", Scope: "gist"},
+		},
+	}
+	bothHosts := "ghe.example.com\n  hubot (active): scopes repo\ngithub.com\n  monalisa (active): scopes gist\n"
+	gheOnly := "ghe.example.com\n  hubot (active): scopes repo\n"
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config fileConfig
+		want   string
+	}{
+		{
+			name: "no host set",
+			want: bothHosts,
+		},
+		{
+			name: "host flag",
+			args: []string{"-host", "ghe.example.com"},
+			want: gheOnly,
+		},
+		{
+			name: "host environment variable",
+			env:  map[string]string{"OAUTH_HOST": "GHE.example.com"},
+			want: gheOnly,
+		},
+		{
+			name:   "default host in config file",
+			config: fileConfig{DefaultHost: "ghe.example.com"},
+			want:   gheOnly,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"OAUTH_CONFIG": writeConfig(t, tt.config)}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			a := newTestApp(t, env)
+			if err := a.store.Save(entries); err != nil {
+				t.Fatal(err)
+			}
+
+			if code := a.exec(append([]string{"status"}, tt.args...)...); code != 0 {
+				t.Fatalf("status: exit code %d: %s", code, a.stderr)
+			}
+			if got := a.stdout.String(); got != tt.want {
+				t.Errorf("status: stdout = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApp_usage(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{
+			name:       "no command",
+			wantCode:   2,
+			wantStderr: "Usage: oauth <command> [flags]",
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"whoami"},
+			wantCode:   2,
+			wantStderr: `oauth: unknown command "whoami"`,
+		},
+		{
+			name:       "unknown flag",
+			args:       []string{"token", "-verbose"},
+			wantCode:   2,
+			wantStderr: "flag provided but not defined: -verbose",
+		},
+		{
+			name:       "no client ID",
+			args:       []string{"login"},
+			wantCode:   1,
+			wantStderr: "oauth login: no client ID for github.com; use -client-id, OAUTH_CLIENT_ID or the config file",
+		},
+		{
+			name:       "missing config file",
+			args:       []string{"login", "-config", "testdata/missing.json"},
+			wantCode:   1,
+			wantStderr: "oauth login: reading the config file: open testdata/missing.json",
+		},
+		{
+			name:       "not logged in",
+			args:       []string{"token", "-host", "ghe.example.com"},
+			wantCode:   1,
+			wantStderr: "oauth token: account not found: no active account on ghe.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, map[string]string{"OAUTH_CONFIG": writeConfig(t, fileConfig{})})
+			if code := a.exec(tt.args...); code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if !strings.Contains(a.stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %q, want %q", a.stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestOptions_resolve(t *testing.T) {
+	config := writeConfig(t, fileConfig{
+		Store: "/var/lib/oauth/accounts.json",
+		Hosts: map[string]hostConfig{
+			"github.com": {ClientID: "FILE-ID", ClientSecret: "FILE-SECRET", Scopes: []string{"gist"}},
+			"ghe.example.com": {
+				ClientID: "GHE-ID",
+				TokenURL: "https://sso.example.com/token",
+			},
+		},
+	})
+
+	tests := []struct {
+		name string
+		opts options
+		env  map[string]string
+		want settings
+	}{
+		{
+			name: "config file",
+			opts: options{configPath: config},
+			want: settings{
+				storePath: "/var/lib/oauth/accounts.json",
+				hostName:  "github.com",
+				hostConfig: hostConfig{
+					ClientID:     "FILE-ID",
+					ClientSecret: "FILE-SECRET",
+					Scopes:       []string{"gist"},
+					CallbackURI:  "http://127.0.0.1/callback",
+				},
+			},
+		},
+		{
+			name: "environment overrides config file",
+			opts: options{configPath: config},
+			env:  map[string]string{"OAUTH_CLIENT_ID": "ENV-ID", "OAUTH_SCOPES": "repo, read:org", "OAUTH_STORE": "/tmp/store.json"},
+			want: settings{
+				storePath: "/tmp/store.json",
+				hostName:  "github.com",
+				hostConfig: hostConfig{
+					ClientID:     "ENV-ID",
+					ClientSecret: "FILE-SECRET",
+					Scopes:       []string{"repo", "read:org"},
+					CallbackURI:  "http://127.0.0.1/callback",
+				},
+			},
+		},
+		{
+			name: "flags override environment",
+			opts: options{configPath: config, host: "GHE.example.com", scopes: "admin:org"},
+			env:  map[string]string{"OAUTH_HOST": "github.com", "OAUTH_SCOPES": "repo"},
+			want: settings{
+				storePath: "/var/lib/oauth/accounts.json",
+				hostName:  "ghe.example.com",
+				hostSet:   true,
+				hostConfig: hostConfig{
+					ClientID:    "GHE-ID",
+					Scopes:      []string{"admin:org"},
+					CallbackURI: "http://127.0.0.1/callback",
+					TokenURL:    "https://sso.example.com/token",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.resolve(func(k string) string { return tt.env[k] })
+			if err != nil {
+				t.Fatalf("resolve() error: %v", err)
+			}
+			host := got.host
+			got.host = nil
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("resolve() = %+v, want %+v", *got, tt.want)
+			}
+			wantTokenURL := "https://" + tt.want.hostName + "/login/oauth/access_token"
+			if tt.want.TokenURL != "" {
+				wantTokenURL = tt.want.TokenURL
+			}
+			if host.TokenURL != wantTokenURL || host.DeviceCodeURL != "https://"+tt.want.hostName+"/login/device/code" {
+				t.Errorf("host = %+v", host)
+			}
+		})
+	}
+}
+
+func TestApp_gitCredential(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID"})
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	env := map[string]string{
+		"GIT_TERMINAL_PROMPT": "0",
+		"OAUTH_CONFIG": writeConfig(t, fileConfig{
+			Hosts: map[string]hostConfig{
+				host: {ClientID: "CLIENT-ID", URL: s.URL},
+			},
+		}),
+	}
+	a := newTestApp(t, env)
+	request := "protocol=http\nhost=" + host + "\n\n"
+
+	a.stdin = strings.NewReader(request)
+	if code := a.exec("git-credential", "-allow-http", "get"); code != 0 || a.stdout.String() != "" {
+		t.Fatalf("get without prompting: exit code %d, stdout %q: %s", code, a.stdout, a.stderr)
+	}
+
+	delete(env, "GIT_TERMINAL_PROMPT")
+	// The test server only speaks plain HTTP, which has to be allowed explicitly.
+	a.stdin = strings.NewReader(request)
+	if code := a.exec("git-credential", "get"); code != 0 || a.stdout.String() != "" || a.stderr.String() != "" {
+		t.Fatalf("get over http: exit code %d, stdout %q: %s", code, a.stdout, a.stderr)
+	}
+	a.stdin = strings.NewReader(request)
+	if code := a.exec("git-credential", "-allow-http", "get"); code != 0 {
+		t.Fatalf("get: exit code %d: %s", code, a.stderr)
+	}
+	password := strings.TrimPrefix(strings.Split(a.stdout.String(), "\n")[1], "password=")
+	if !strings.HasPrefix(a.stdout.String(), "username=monalisa\npassword=") || !s.Valid(password) {
+		t.Errorf("get: stdout = %q", a.stdout)
+	}
+
+	// Other hosts are left to other helpers.
+	a.stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+	if code := a.exec("git-credential", "get"); code != 0 || a.stdout.String() != "" {
+		t.Errorf("get for github.com: exit code %d, stdout %q: %s", code, a.stdout, a.stderr)
+	}
+
+	if code := a.exec("git-credential"); code != 2 {
+		t.Errorf("missing operation: exit code %d", code)
+	}
+}
+
+func TestApp_kubeCredential(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID"})
+	defer s.Close()
+
+	env := map[string]string{
+		"OAUTH_CONFIG": writeConfig(t, fileConfig{
+			Hosts: map[string]hostConfig{
+				"github.com": {ClientID: "CLIENT-ID", URL: s.URL},
+			},
+		}),
+		"KUBERNETES_EXEC_INFO": `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`,
+	}
+	a := newTestApp(t, env)
+
+	if code := a.exec("kube-credential"); code != 1 || !strings.Contains(a.stderr.String(), "not logged in to github.com") {
+		t.Errorf("kube-credential without logging in: exit code %d: %s", code, a.stderr)
+	}
+
+	env["KUBERNETES_EXEC_INFO"] = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":true}}`
+	if code := a.exec("kube-credential"); code != 0 {
+		t.Fatalf("kube-credential: exit code %d: %s", code, a.stderr)
+	}
+	var cred struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(a.stdout.Bytes(), &cred); err != nil || !s.Valid(cred.Status.Token) {
+		t.Errorf("kube-credential: stdout = %q", a.stdout)
+	}
+}
+
+func TestApp_dockerCredential(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID"})
+	defer s.Close()
+
+	a := newTestApp(t, map[string]string{
+		"OAUTH_CONFIG": writeConfig(t, fileConfig{
+			Hosts: map[string]hostConfig{
+				"github.com": {ClientID: "CLIENT-ID", URL: s.URL},
+			},
+		}),
+	})
+
+	a.stdin = strings.NewReader("ghcr.io\n")
+	if code := a.exec("docker-credential", "get"); code != 1 || a.stdout.String() != "credentials not found in native keychain\n" || a.stderr.Len() != 0 {
+		t.Errorf("get without logging in: exit code %d, stdout %q, stderr %q", code, a.stdout, a.stderr)
+	}
+
+	a.stdin = strings.NewReader("\n")
+	if code := a.exec("login"); code != 0 {
+		t.Fatalf("login: exit code %d: %s", code, a.stderr)
+	}
+
+	a.stdin = strings.NewReader("ghcr.io\n")
+	if code := a.exec("docker-credential", "get"); code != 0 {
+		t.Fatalf("get: exit code %d: %s", code, a.stdout)
+	}
+	var creds struct{ ServerURL, Username, Secret string }
+	if err := json.Unmarshal(a.stdout.Bytes(), &creds); err != nil || creds.Username != "monalisa" || !s.Valid(creds.Secret) {
+		t.Errorf("get: stdout = %q", a.stdout)
+	}
+
+	if code := a.exec("docker-credential", "-registries", "registry.example.com", "list"); code != 0 || a.stdout.String() != `{"registry.example.com":"monalisa"}`+"\n" {
+		t.Errorf("list: exit code %d, stdout %q", code, a.stdout)
+	}
+}
@@ -16,7 +16,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"log/slog"
 	"net/url"
 	"strconv"
 	"strings"
@@ -32,10 +32,6 @@ var (
 	ErrTimeout = errors.New("authentication timed out")
 )
 
-type httpClient interface {
-	PostForm(string, url.Values) (*http.Response, error)
-}
-
 // CodeResponse holds information about the authorization-in-progress.
 type CodeResponse struct {
 	// The user verification code is displayed on the device so the user can enter the code in a browser.
@@ -54,35 +50,51 @@ type CodeResponse struct {
 	Interval int
 }
 
+// LogValue implements slog.LogValuer so that logging a code response never reveals the device code.
+func (c CodeResponse) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("user_code", c.UserCode),
+		slog.String("verification_uri", c.VerificationURI),
+		slog.Int("expires_in", c.ExpiresIn),
+		slog.Int("interval", c.Interval),
+	)
+}
+
 // AuthRequestEditorFn defines the function signature for setting additional form values.
-type AuthRequestEditorFn func(*url.Values)
+type AuthRequestEditorFn = api.AuthRequestEditorFn
 
 // WithAudience sets the audience parameter in the request.
 func WithAudience(audience string) AuthRequestEditorFn {
-	return func(values *url.Values) {
-		if audience != "" {
-			values.Add("audience", audience)
-		}
-	}
+	return api.WithAudience(audience)
 }
 
 // RequestCode initiates the authorization flow by requesting a code from uri.
-func RequestCode(c httpClient, uri string, clientID string, scopes []string,
+func RequestCode(c api.Doer, uri string, clientID string, scopes []string,
 	optionalRequestParams ...AuthRequestEditorFn) (*CodeResponse, error) {
+	return RequestCodeContext(context.Background(), c, uri, clientID, scopes, api.RequestOptions{}, optionalRequestParams...)
+}
+
+// RequestCodeContext is like RequestCode, but binds the request to ctx and customizes it with opts.
+// If the server rejects the request due to rate limiting, the error is an *api.RateLimitError.
+func RequestCodeContext(ctx context.Context, c api.Doer, uri string, clientID string, scopes []string,
+	opts api.RequestOptions, optionalRequestParams ...AuthRequestEditorFn) (*CodeResponse, error) {
 	values := url.Values{
 		"client_id": {clientID},
 		"scope":     {strings.Join(scopes, " ")},
 	}
 
-	for _, fn := range optionalRequestParams {
-		fn(&values)
-	}
+	api.EditParams(values, optionalRequestParams...)
 
-	resp, err := api.PostForm(c, uri, values)
+	resp, err := api.PostForm(ctx, c, uri, values, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	// Rate limiting is reported with HTTP 403 as well, which must not be taken for a lack of support.
+	if rateLimit := resp.RateLimit(time.Now()); rateLimit != nil {
+		return nil, rateLimit
+	}
+
 	verificationURI := resp.Get("verification_uri")
 	if verificationURI == "" {
 		// Google's "OAuth 2.0 for TV and Limited-Input Device Applications" uses `verification_url`.
@@ -91,8 +103,8 @@ func RequestCode(c httpClient, uri string, clientID string, scopes []string,
 
 	if resp.StatusCode == 401 || resp.StatusCode == 403 || resp.StatusCode == 404 || resp.StatusCode == 422 ||
 		(resp.StatusCode == 200 && verificationURI == "") ||
-		(resp.StatusCode == 400 && resp.Get("error") == "device_flow_disabled") ||
-		(resp.StatusCode == 400 && resp.Get("error") == "unauthorized_client") {
+		(resp.StatusCode == 400 && resp.Get("error") == string(api.ErrDeviceFlowDisabled)) ||
+		(resp.StatusCode == 400 && resp.Get("error") == string(api.ErrUnauthorizedClient)) {
 		return nil, ErrUnsupported
 	}
 
@@ -125,7 +137,7 @@ const defaultGrantType = "urn:ietf:params:oauth:grant-type:device_code"
 // PollToken polls the server at pollURL until an access token is granted or denied.
 //
 // Deprecated: use Wait.
-func PollToken(c httpClient, pollURL string, clientID string, code *CodeResponse) (*api.AccessToken, error) {
+func PollToken(c api.Doer, pollURL string, clientID string, code *CodeResponse) (*api.AccessToken, error) {
 	return Wait(context.Background(), c, pollURL, WaitOptions{
 		ClientID:   clientID,
 		DeviceCode: code,
@@ -142,8 +154,22 @@ type WaitOptions struct {
 	DeviceCode *CodeResponse
 	// GrantType overrides the default value specified by OAuth 2.0 Device Code. Optional.
 	GrantType string
+	// Retry configures retrying of transient polling failures. Optional: by default, any failure to
+	// reach the server aborts the wait.
+	Retry RetryPolicy
+	// Clock is the source of time used for polling and retries. Optional: defaults to the system clock.
+	Clock Clock
+	// NewPoller creates the Poller that paces requests to the server. Optional: defaults to NewPoller
+	// using Clock.
+	NewPoller PollerFactory
+	// Logger receives debug records for every request and polling decision. Optional. Secret values
+	// are redacted.
+	Logger *slog.Logger
+	// RequestOptions customizes the HTTP requests made while polling, e.g. to set the User-Agent. Optional.
+	RequestOptions api.RequestOptions
+	// RequestEditors set additional form values on every token request. Optional.
+	RequestEditors []api.AuthRequestEditorFn
 
-	newPoller                pollerFactory
 	calculateTimeDriftRatioF func(tstart, tstop time.Time) float64
 }
 
@@ -152,8 +178,12 @@ const (
 	secondaryIntervalMultiplier = 1.4
 )
 
+// maxRateLimitWaits is the number of times in a row that Wait waits for a rate limit to reset before
+// giving up, in case the server keeps rejecting requests.
+const maxRateLimitWaits = 5
+
 // Wait polls the server at uri until authorization completes.
-func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api.AccessToken, error) {
+func Wait(ctx context.Context, c api.Doer, uri string, opts WaitOptions) (*api.AccessToken, error) {
 	// We know that in virtualised environments (e.g. WSL or VMs), the monotonic
 	// clock, which is the source of time measurements in Go, can run faster than
 	// real time. So, polling intervals should be adjusted to avoid falling into
@@ -180,18 +210,30 @@ func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api
 	// an indication of severe clock drift. In such cases, we'll report the
 	// measured clock drift to hint the user at the root cause.
 
+	clock := opts.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	logger := api.RedactLogger(opts.Logger)
+	if opts.Logger != nil {
+		c = &api.LoggingClient{Client: c, Logger: opts.Logger}
+	}
+
 	baseCheckInterval := time.Duration(opts.DeviceCode.Interval) * time.Second
 	expiresIn := time.Duration(opts.DeviceCode.ExpiresIn) * time.Second
+	expiresAt := clock.Now().Add(expiresIn)
 	grantType := opts.GrantType
 	if opts.GrantType == "" {
 		grantType = defaultGrantType
 	}
 
-	makePoller := opts.newPoller
+	makePoller := opts.NewPoller
 	if makePoller == nil {
-		makePoller = newPoller
+		makePoller = NewPoller(clock)
 	}
 	_, poll := makePoller(ctx, baseCheckInterval, expiresIn)
+	defer poll.Cancel()
 
 	calculateTimeDriftRatioF := opts.calculateTimeDriftRatioF
 	if calculateTimeDriftRatioF == nil {
@@ -200,15 +242,27 @@ func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api
 
 	multiplier := primaryIntervalMultiplier
 
-	var slowDowns int
+	var slowDowns, failures, rateLimits int
+	// retrying is set after sleeping for a retry, which then takes the place of the polling interval.
+	var retrying bool
 	for {
-		tstart := time.Now()
-
-		if err := poll.Wait(multiplier); err != nil {
-			return nil, err
+		tstart := clock.Now()
+
+		if !retrying {
+			if err := poll.Wait(multiplier); err != nil {
+				// The poller's own deadline is derived from the code's expiry, so reaching it while the
+				// caller's context is still live means the code has expired.
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+					logger.Debug("device code expired")
+					return nil, ErrTimeout
+				}
+				logger.Debug("stopped polling", "error", err)
+				return nil, err
+			}
 		}
+		retrying = false
 
-		tstop := time.Now()
+		tstop := clock.Now()
 
 		values := url.Values{
 			"client_id":   {opts.ClientID},
@@ -220,29 +274,93 @@ func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api
 		if opts.ClientSecret != "" {
 			values.Add("client_secret", opts.ClientSecret)
 		}
+		api.EditParams(values, opts.RequestEditors...)
 
-		// TODO: pass tctx down to the HTTP layer
-		resp, err := api.PostForm(c, uri, values)
+		resp, err := api.PostForm(ctx, c, uri, values, opts.RequestOptions)
+		if err == nil {
+			if rateLimit := resp.RateLimit(clock.Now()); rateLimit != nil {
+				switch {
+				case rateLimit.Reset.IsZero():
+					// Without a known reset time, treat the rejection like any other transient failure.
+					err = rateLimit
+				case rateLimit.Reset.After(expiresAt):
+					logger.Debug("giving up polling; rate limit outlives device code", "reset", rateLimit.Reset)
+					return nil, rateLimit
+				case rateLimits >= maxRateLimitWaits:
+					logger.Debug("giving up polling; still rate limited after waiting", "waits", rateLimits)
+					return nil, rateLimit
+				default:
+					rateLimits++
+					// A reset time in the past, e.g. due to clock skew, must not make us poll in a tight loop.
+					delay := rateLimit.Reset.Sub(clock.Now())
+					if interval := scaleInterval(poll.GetInterval(), multiplier); delay < interval {
+						delay = interval
+					}
+					logger.Debug("rate limited; waiting for reset", "reset", rateLimit.Reset, "delay", delay)
+					if err := sleep(ctx, clock, delay); err != nil {
+						return nil, err
+					}
+					retrying = true
+					continue
+				}
+			} else if resp.StatusCode >= 500 {
+				err = resp.Err()
+			}
+		}
+		rateLimits = 0
 		if err != nil {
-			return nil, err
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			failures++
+			if failures > opts.Retry.MaxConsecutiveFailures {
+				logger.Debug("giving up polling", "error", err, "failures", failures)
+				return nil, giveUpError(failures, err)
+			}
+
+			// Polling sooner than the interval would earn a slow_down, which is taken for clock drift
+			// when it happens twice.
+			delay := opts.Retry.backoff(failures)
+			if interval := scaleInterval(poll.GetInterval(), multiplier); delay < interval {
+				delay = interval
+			}
+			if resp != nil {
+				if retryAfter := resp.RetryAfter(clock.Now()); retryAfter > delay {
+					delay = retryAfter
+				}
+			}
+			// Retrying past the code's expiry is futile, so report the failure that got us here instead.
+			if clock.Now().Add(delay).After(expiresAt) {
+				logger.Debug("giving up polling; retry would outlive device code", "error", err, "failures", failures)
+				return nil, giveUpError(failures, err)
+			}
+			logger.Debug("polling failed; retrying", "error", err, "failures", failures, "delay", delay)
+			if err := sleep(ctx, clock, delay); err != nil {
+				return nil, err
+			}
+			retrying = true
+			continue
 		}
+		failures = 0
 
-		var apiError *api.Error
 		token, err := resp.AccessToken()
 		if err == nil {
+			logger.Debug("access token granted", "token", token)
 			return token, nil
 		}
 
+		var apiError *api.Error
 		if !errors.As(err, &apiError) {
 			return nil, err
 		}
 
-		if apiError.Code == "authorization_pending" {
+		if errors.Is(apiError, api.ErrAuthorizationPending) {
 			// Keep polling
+			logger.Debug("authorization pending; polling again", "interval", poll.GetInterval())
 			continue
 		}
 
-		if apiError.Code == "slow_down" {
+		if errors.Is(apiError, api.ErrSlowDown) {
 			slowDowns++
 
 			// Since we have already added the secondary safety multiplier upon
@@ -252,6 +370,7 @@ func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api
 			// So, we bail out and inform the user about the potential cause.
 			if slowDowns > 1 {
 				driftRatio := calculateTimeDriftRatioF(tstart, tstop)
+				logger.Debug("giving up polling after repeated slow_down", "drift_ratio", driftRatio)
 				return nil, fmt.Errorf("too many slow_down responses; detected clock drift of roughly %.0f%% between monotonic and wall clocks; please ensure your system clock is accurate", driftRatio*100)
 			}
 
@@ -268,15 +387,30 @@ func Wait(ctx context.Context, c httpClient, uri string, opts WaitOptions) (*api
 				}
 			}
 
+			logger.Debug("server asked to slow down; polling less often", "interval", newInterval)
 			poll.SetInterval(newInterval)
 			multiplier = secondaryIntervalMultiplier
 			continue
 		}
 
+		if errors.Is(apiError, api.ErrExpiredToken) {
+			logger.Debug("device code expired")
+			return nil, fmt.Errorf("%w: %w", ErrTimeout, apiError)
+		}
+
+		logger.Debug("authorization failed", "error", err)
+
 		return nil, err
 	}
 }
 
+func giveUpError(failures int, err error) error {
+	if failures <= 1 {
+		return err
+	}
+	return fmt.Errorf("polling failed %d consecutive times: %w", failures, err)
+}
+
 func calculateTimeDriftRatio(tstart, tstop time.Time) float64 {
 	elapsedWall := tstop.UnixNano() - tstart.UnixNano()
 	elapsedMono := tstop.Sub(tstart).Nanoseconds()
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,6 +19,8 @@ type apiStub struct {
 	status      int
 	body        string
 	contentType string
+	header      http.Header
+	err         error
 }
 
 type postArgs struct {
@@ -36,13 +39,20 @@ func (c *apiClient) PostForm(u string, params url.Values) (*http.Response, error
 	stub := c.stubs[c.postCount]
 	c.calls = append(c.calls, postArgs{url: u, params: params})
 	c.postCount++
-	return &http.Response{
+	if stub.err != nil {
+		return nil, stub.err
+	}
+	resp := &http.Response{
 		Body: io.NopCloser(bytes.NewBufferString(stub.body)),
 		Header: http.Header{
 			"Content-Type": {stub.contentType},
 		},
 		StatusCode: stub.status,
-	}, nil
+	}
+	for k, vs := range stub.header {
+		resp.Header[k] = vs
+	}
+	return resp, nil
 }
 
 func TestRequestCode(t *testing.T) {
@@ -260,7 +270,38 @@ func TestRequestCode(t *testing.T) {
 				clientID: "CLIENT-ID",
 				scopes:   []string{"repo", "gist"},
 			},
-			wantErr: "HTTP 502",
+			wantErr: "HTTP 502: server responded with text/html instead of a form or JSON: Something went wrong",
+			posts: []postArgs{
+				{
+					url: "https://github.com/oauth",
+					params: url.Values{
+						"client_id": {"CLIENT-ID"},
+						"scope":     {"repo gist"},
+					},
+				},
+			},
+		},
+		{
+			name: "rate limited",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							body:        `{"message":"API rate limit exceeded"}`,
+							status:      403,
+							contentType: "application/json",
+							header: http.Header{
+								"X-Ratelimit-Remaining": {"0"},
+								"X-Ratelimit-Reset":     {"1714564800"},
+							},
+						},
+					},
+				},
+				url:      "https://github.com/oauth",
+				clientID: "CLIENT-ID",
+				scopes:   []string{"repo", "gist"},
+			},
+			wantErr: "rate limit exceeded; try again after 2024-05-01T12:00:00Z: HTTP 403",
 			posts: []postArgs{
 				{
 					url: "https://github.com/oauth",
@@ -274,7 +315,7 @@ func TestRequestCode(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := RequestCode(&tt.args.http, tt.args.url,
+			got, err := RequestCode(api.FromFormPoster(&tt.args.http), tt.args.url,
 				tt.args.clientID, tt.args.scopes, WithAudience(tt.args.audience))
 			if (err != nil) != (tt.wantErr != "") {
 				t.Errorf("RequestCode() error = %v, wantErr %v", err, tt.wantErr)
@@ -311,9 +352,9 @@ func TestPollToken(t *testing.T) {
 		}
 	}
 
-	singletonFakePoller := func(maxWaits int) pollerFactory {
+	singletonFakePoller := func(maxWaits int) PollerFactory {
 		var instance *fakePoller
-		return func(ctx context.Context, interval, _ time.Duration) (context.Context, poller) {
+		return func(ctx context.Context, interval, _ time.Duration) (context.Context, Poller) {
 			if instance != nil {
 				// This is to make the factory return the same instance in tests.
 				return ctx, instance
@@ -372,7 +413,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(2),
+					NewPoller: singletonFakePoller(2),
 				},
 			},
 			want: &api.AccessToken{
@@ -388,7 +429,7 @@ func TestPollToken(t *testing.T) {
 			}),
 			assertFunc: func(t *testing.T, a args) {
 				// Get the created poller
-				_, poller := a.opts.newPoller(context.Background(), 0, 0)
+				_, poller := a.opts.NewPoller(context.Background(), 0, 0)
 				if poller.(*fakePoller).updatedIntervals != nil {
 					t.Errorf("no interval change expected = %v", poller.(*fakePoller).updatedIntervals)
 				}
@@ -427,7 +468,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(3),
+					NewPoller: singletonFakePoller(3),
 				},
 			},
 			want: &api.AccessToken{
@@ -443,7 +484,7 @@ func TestPollToken(t *testing.T) {
 			}),
 			assertFunc: func(t *testing.T, a args) {
 				// Get the created poller
-				_, poller := a.opts.newPoller(context.Background(), 0, 0)
+				_, poller := a.opts.NewPoller(context.Background(), 0, 0)
 				got := poller.(*fakePoller).updatedIntervals
 				want := []time.Duration{22 * time.Second}
 				if !reflect.DeepEqual(got, want) {
@@ -484,7 +525,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(3),
+					NewPoller: singletonFakePoller(3),
 				},
 			},
 			want: &api.AccessToken{
@@ -500,7 +541,7 @@ func TestPollToken(t *testing.T) {
 			}),
 			assertFunc: func(t *testing.T, a args) {
 				// Get the created poller
-				_, poller := a.opts.newPoller(context.Background(), 0, 0)
+				_, poller := a.opts.NewPoller(context.Background(), 0, 0)
 				got := poller.(*fakePoller).updatedIntervals
 				want := []time.Duration{10 * time.Second}
 				if !reflect.DeepEqual(got, want) {
@@ -541,7 +582,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller:                singletonFakePoller(3),
+					NewPoller:                singletonFakePoller(3),
 					calculateTimeDriftRatioF: newCalculateTimeDriftRatioStub(0.10),
 				},
 			},
@@ -556,7 +597,7 @@ func TestPollToken(t *testing.T) {
 			}),
 			assertFunc: func(t *testing.T, a args) {
 				// Get the created poller
-				_, poller := a.opts.newPoller(context.Background(), 0, 0)
+				_, poller := a.opts.NewPoller(context.Background(), 0, 0)
 				got := poller.(*fakePoller).updatedIntervals
 				want := []time.Duration{10 * time.Second}
 				if !reflect.DeepEqual(got, want) {
@@ -589,7 +630,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(1),
+					NewPoller: singletonFakePoller(1),
 				},
 			},
 			want: &api.AccessToken{
@@ -634,10 +675,10 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       14,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(2),
+					NewPoller: singletonFakePoller(2),
 				},
 			},
-			wantErr: "context deadline exceeded",
+			wantErr: "authentication timed out",
 			posts: repeatPostArgs(2, postArgs{
 				url: "https://github.com/oauth",
 				params: url.Values{
@@ -669,7 +710,7 @@ func TestPollToken(t *testing.T) {
 						ExpiresIn:       99,
 						Interval:        5,
 					},
-					newPoller: singletonFakePoller(1),
+					NewPoller: singletonFakePoller(1),
 				},
 			},
 			wantErr: "access_denied",
@@ -684,10 +725,225 @@ func TestPollToken(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "expired token",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							body:        "error=authorization_pending",
+							status:      200,
+							contentType: "application/x-www-form-urlencoded; charset=utf-8",
+						},
+						{
+							body:        "error=expired_token&error_description=This+%27device_code%27+has+expired.",
+							status:      200,
+							contentType: "application/x-www-form-urlencoded; charset=utf-8",
+						},
+					},
+				},
+				url: "https://github.com/oauth",
+				opts: WaitOptions{
+					ClientID: "CLIENT-ID",
+					DeviceCode: &CodeResponse{
+						DeviceCode:      "DEVIC",
+						UserCode:        "123-abc",
+						VerificationURI: "http://verify.me",
+						ExpiresIn:       99,
+						Interval:        5,
+					},
+					NewPoller: singletonFakePoller(2),
+				},
+			},
+			wantErr: "authentication timed out: This 'device_code' has expired. (expired_token)",
+			posts: repeatPostArgs(2, postArgs{
+				url: "https://github.com/oauth",
+				params: url.Values{
+					"client_id":   {"CLIENT-ID"},
+					"device_code": {"DEVIC"},
+					"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+				},
+			}),
+		},
+		{
+			name: "retries transient failures",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							err: errors.New("connection reset by peer"),
+						},
+						{
+							body:        "<h1>Bad gateway</h1>",
+							status:      502,
+							contentType: "text/html",
+						},
+						{
+							body:        "access_token=123abc",
+							status:      200,
+							contentType: "application/x-www-form-urlencoded; charset=utf-8",
+						},
+					},
+				},
+				url: "https://github.com/oauth",
+				opts: WaitOptions{
+					ClientID: "CLIENT-ID",
+					DeviceCode: &CodeResponse{
+						DeviceCode:      "DEVIC",
+						UserCode:        "123-abc",
+						VerificationURI: "http://verify.me",
+						ExpiresIn:       99,
+						Interval:        5,
+					},
+					Retry: RetryPolicy{
+						MaxConsecutiveFailures: 2,
+						InitialBackoff:         time.Millisecond,
+					},
+					NewPoller: singletonFakePoller(3),
+				},
+			},
+			want: &api.AccessToken{
+				Token: "123abc",
+			},
+			posts: repeatPostArgs(3, postArgs{
+				url: "https://github.com/oauth",
+				params: url.Values{
+					"client_id":   {"CLIENT-ID"},
+					"device_code": {"DEVIC"},
+					"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+				},
+			}),
+		},
+		{
+			name: "gives up after consecutive failures",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							err: errors.New("connection reset by peer"),
+						},
+						{
+							body:        "error=authorization_pending",
+							status:      200,
+							contentType: "application/x-www-form-urlencoded; charset=utf-8",
+						},
+						{
+							body:        "<h1>Bad gateway</h1>",
+							status:      502,
+							contentType: "text/html",
+						},
+						{
+							body:        "<h1>Bad gateway</h1>",
+							status:      502,
+							contentType: "text/html",
+						},
+					},
+				},
+				url: "https://github.com/oauth",
+				opts: WaitOptions{
+					ClientID: "CLIENT-ID",
+					DeviceCode: &CodeResponse{
+						DeviceCode:      "DEVIC",
+						UserCode:        "123-abc",
+						VerificationURI: "http://verify.me",
+						ExpiresIn:       99,
+						Interval:        5,
+					},
+					Retry: RetryPolicy{
+						MaxConsecutiveFailures: 1,
+						InitialBackoff:         time.Millisecond,
+					},
+					NewPoller: singletonFakePoller(4),
+				},
+			},
+			wantErr: "polling failed 2 consecutive times: HTTP 502: server responded with text/html instead of a form or JSON: Bad gateway",
+			posts: repeatPostArgs(4, postArgs{
+				url: "https://github.com/oauth",
+				params: url.Values{
+					"client_id":   {"CLIENT-ID"},
+					"device_code": {"DEVIC"},
+					"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+				},
+			}),
+		},
+		{
+			name: "no retries by default",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							err: errors.New("connection reset by peer"),
+						},
+					},
+				},
+				url: "https://github.com/oauth",
+				opts: WaitOptions{
+					ClientID: "CLIENT-ID",
+					DeviceCode: &CodeResponse{
+						DeviceCode:      "DEVIC",
+						UserCode:        "123-abc",
+						VerificationURI: "http://verify.me",
+						ExpiresIn:       99,
+						Interval:        5,
+					},
+					NewPoller: singletonFakePoller(1),
+				},
+			},
+			wantErr: "connection reset by peer",
+			posts: []postArgs{
+				{
+					url: "https://github.com/oauth",
+					params: url.Values{
+						"client_id":   {"CLIENT-ID"},
+						"device_code": {"DEVIC"},
+						"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+					},
+				},
+			},
+		},
+		{
+			name: "retry would outlive the device code",
+			args: args{
+				http: apiClient{
+					stubs: []apiStub{
+						{
+							err: errors.New("connection reset by peer"),
+						},
+					},
+				},
+				url: "https://github.com/oauth",
+				opts: WaitOptions{
+					ClientID: "CLIENT-ID",
+					DeviceCode: &CodeResponse{
+						DeviceCode:      "DEVIC",
+						UserCode:        "123-abc",
+						VerificationURI: "http://verify.me",
+						ExpiresIn:       1,
+						Interval:        5,
+					},
+					Retry: RetryPolicy{
+						MaxConsecutiveFailures: 5,
+						InitialBackoff:         time.Minute,
+					},
+					NewPoller: singletonFakePoller(1),
+				},
+			},
+			wantErr: "connection reset by peer",
+			posts: []postArgs{
+				{
+					url: "https://github.com/oauth",
+					params: url.Values{
+						"client_id":   {"CLIENT-ID"},
+						"device_code": {"DEVIC"},
+						"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := Wait(context.Background(), &tt.args.http, tt.args.url, tt.args.opts)
+			got, err := Wait(context.Background(), api.FromFormPoster(&tt.args.http), tt.args.url, tt.args.opts)
 			if (err != nil) != (tt.wantErr != "") {
 				t.Errorf("PollToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -695,6 +951,9 @@ func TestPollToken(t *testing.T) {
 			if tt.wantErr != "" && err.Error() != tt.wantErr {
 				t.Errorf("PollToken error = %q, want %q", err.Error(), tt.wantErr)
 			}
+			if strings.HasPrefix(tt.wantErr, ErrTimeout.Error()) && !errors.Is(err, ErrTimeout) {
+				t.Errorf("PollToken error = %v, want ErrTimeout", err)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("PollToken() = %v, want %v", got, tt.want)
 			}
@@ -728,7 +987,7 @@ func (p *fakePoller) SetInterval(d time.Duration) {
 
 func (p *fakePoller) Wait(multiplier float64) error {
 	if p.count == p.maxWaits {
-		return errors.New("context deadline exceeded")
+		return context.DeadlineExceeded
 	}
 	p.waitMultipliers = append(p.waitMultipliers, multiplier)
 	p.count++
@@ -737,3 +996,26 @@ func (p *fakePoller) Wait(multiplier float64) error {
 
 func (p *fakePoller) Cancel() {
 }
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	}
+	tests := []struct {
+		failures int
+		min, max time.Duration
+	}{
+		{failures: 1, min: 500 * time.Millisecond, max: time.Second},
+		{failures: 2, min: time.Second, max: 2 * time.Second},
+		{failures: 3, min: 2 * time.Second, max: 4 * time.Second},
+		{failures: 10, min: 5 * time.Second, max: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := p.backoff(tt.failures); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.failures, got, tt.min, tt.max)
+			}
+		}
+	}
+}
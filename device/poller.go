@@ -6,27 +6,86 @@ import (
 	"time"
 )
 
-type poller interface {
+// Clock tells the current time and schedules wake-ups for Wait. Tests can substitute a fake
+// implementation to control the passage of time instead of sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a Timer that sends the current time on its channel once d has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a wake-up scheduled by a Clock. Wait stops the timers it no longer needs, so that they do
+// not hold on to resources until they fire.
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It reports whether the timer was stopped before it fired.
+	Stop() bool
+}
+
+// SystemClock returns the Clock that Wait uses by default, which follows the system time.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// Poller paces the requests that Wait makes to the token endpoint.
+type Poller interface {
+	// GetInterval returns the current interval between requests.
 	GetInterval() time.Duration
+	// SetInterval changes the interval between requests, e.g. after the server asked to slow down.
 	SetInterval(time.Duration)
+	// Wait blocks for the current interval scaled by multiplier. It returns an error once the device
+	// code has expired or the context is done.
 	Wait(multiplier float64) error
+	// Cancel releases the resources associated with the poller.
 	Cancel()
 }
 
-type pollerFactory func(context.Context, time.Duration, time.Duration) (context.Context, poller)
+// PollerFactory creates a Poller that waits checkInterval between requests until expiresIn has passed.
+type PollerFactory func(ctx context.Context, checkInterval, expiresIn time.Duration) (context.Context, Poller)
 
-func newPoller(ctx context.Context, checkInterval, expiresIn time.Duration) (context.Context, poller) {
-	c, cancel := context.WithTimeout(ctx, expiresIn)
-	return c, &intervalPoller{
-		ctx:        c,
-		interval:   checkInterval,
-		cancelFunc: cancel,
+// NewPoller returns a PollerFactory for the default Poller, which measures time using clock.
+func NewPoller(clock Clock) PollerFactory {
+	return func(ctx context.Context, checkInterval, expiresIn time.Duration) (context.Context, Poller) {
+		c, cancel := context.WithCancel(ctx)
+		return c, &intervalPoller{
+			ctx:        c,
+			clock:      clock,
+			interval:   checkInterval,
+			deadline:   clock.Now().Add(expiresIn),
+			cancelFunc: cancel,
+		}
 	}
 }
 
 type intervalPoller struct {
 	ctx        context.Context
+	clock      Clock
 	interval   time.Duration
+	deadline   time.Time
 	cancelFunc func()
 }
 
@@ -39,13 +98,27 @@ func (p *intervalPoller) SetInterval(d time.Duration) {
 }
 
 func (p *intervalPoller) Wait(multiplier float64) error {
-	interval := time.Duration(math.Ceil(float64(p.interval) * multiplier))
-	t := time.NewTimer(interval)
+	interval := scaleInterval(p.interval, multiplier)
+	remaining := p.deadline.Sub(p.clock.Now())
+	if remaining <= 0 {
+		return context.DeadlineExceeded
+	}
+
+	expires := interval >= remaining
+	if expires {
+		interval = remaining
+	}
+
+	timer := p.clock.NewTimer(interval)
+	defer timer.Stop()
+
 	select {
 	case <-p.ctx.Done():
-		t.Stop()
 		return p.ctx.Err()
-	case <-t.C:
+	case <-timer.C():
+		if expires {
+			return context.DeadlineExceeded
+		}
 		return nil
 	}
 }
@@ -53,3 +126,8 @@ func (p *intervalPoller) Wait(multiplier float64) error {
 func (p *intervalPoller) Cancel() {
 	p.cancelFunc()
 }
+
+// scaleInterval returns the polling interval d scaled by multiplier, rounded up.
+func scaleInterval(d time.Duration, multiplier float64) time.Duration {
+	return time.Duration(math.Ceil(float64(d) * multiplier))
+}
@@ -0,0 +1,62 @@
+package device
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 1 * time.Second
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+// RetryPolicy controls how Wait recovers from transient failures while polling, such as network errors
+// or HTTP 5xx responses. The backoff delay replaces the polling interval before a retry, but is never
+// shorter than the interval, so that retries do not poll faster than the server allows. The zero
+// value disables retries.
+type RetryPolicy struct {
+	// MaxConsecutiveFailures is the number of transient failures in a row that are retried before Wait
+	// gives up and returns the last error.
+	MaxConsecutiveFailures int
+	// InitialBackoff is the delay before the first retry. Defaults to 1 second.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially growing delay between retries. Defaults to 30 seconds.
+	MaxBackoff time.Duration
+}
+
+// backoff returns the delay before retrying after the given number of consecutive failures. The delay
+// doubles with each failure and is randomized to avoid many clients retrying in lockstep.
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	d := initial
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
@@ -0,0 +1,434 @@
+package device_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/device"
+	"github.com/cli/oauth/oauthtest"
+)
+
+type formClient struct {
+	bodies []string
+	// statuses and headers optionally override the status code and add response headers, by index.
+	statuses []int
+	headers  []http.Header
+	posts    int
+}
+
+func (c *formClient) PostForm(string, url.Values) (*http.Response, error) {
+	i := c.posts
+	c.posts++
+	resp := &http.Response{
+		Body: io.NopCloser(bytes.NewBufferString(c.bodies[i])),
+		Header: http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		},
+		StatusCode: 200,
+	}
+	if i < len(c.statuses) && c.statuses[i] != 0 {
+		resp.StatusCode = c.statuses[i]
+	}
+	if i < len(c.headers) {
+		for k, vs := range c.headers[i] {
+			resp.Header[k] = vs
+		}
+	}
+	return resp, nil
+}
+
+func TestWait_fakeClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := oauthtest.NewClock(start)
+	clock.AutoAdvance = true
+
+	client := &formClient{
+		bodies: []string{
+			"error=authorization_pending",
+			"error=authorization_pending",
+			"access_token=123abc",
+		},
+	}
+
+	token, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "123abc" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if got, want := clock.Now().Sub(start), 18*time.Second; got != want {
+		t.Errorf("waited %v, want %v", got, want)
+	}
+}
+
+func TestWait_fakeClockExpiry(t *testing.T) {
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.AutoAdvance = true
+
+	client := &formClient{
+		bodies: []string{
+			"error=authorization_pending",
+			"error=authorization_pending",
+		},
+	}
+
+	_, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  15,
+			Interval:   5,
+		},
+		Clock: clock,
+	})
+	if !errors.Is(err, device.ErrTimeout) {
+		t.Fatalf("Wait() error = %v, want ErrTimeout", err)
+	}
+	if client.posts != 2 {
+		t.Errorf("expected 2 HTTP POSTs, got %d", client.posts)
+	}
+}
+
+func TestWait_logging(t *testing.T) {
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.AutoAdvance = true
+
+	client := &formClient{
+		bodies: []string{
+			"error=authorization_pending",
+			"access_token=gho_SEKRIT&token_type=bearer",
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	_, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC-SEKRIT",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Clock:  clock,
+		Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	logged := buf.String()
+	if strings.Contains(logged, "SEKRIT") {
+		t.Errorf("secret leaked into log: %s", logged)
+	}
+	for _, want := range []string{"authorization pending", "access token granted", "HTTP exchange"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log does not contain %q: %s", want, logged)
+		}
+	}
+}
+
+func TestWait_rateLimited(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := oauthtest.NewClock(start)
+	clock.AutoAdvance = true
+
+	client := &formClient{
+		bodies: []string{
+			"error=rate_limited",
+			"access_token=123abc",
+		},
+		statuses: []int{http.StatusTooManyRequests},
+		headers: []http.Header{
+			{"Retry-After": {"60"}},
+		},
+	}
+
+	token, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "123abc" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	// The first poll waits 6s, and the wait for the reset replaces the polling interval.
+	if got, want := clock.Now().Sub(start), 66*time.Second; got != want {
+		t.Errorf("waited %v, want %v", got, want)
+	}
+}
+
+func TestWait_rateLimitResetInPast(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rateLimited := http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(start.Add(-time.Hour).Unix(), 10)},
+	}
+	tests := []struct {
+		name      string
+		limits    int
+		wantErr   bool
+		wantPosts int
+		wantWait  time.Duration
+	}{
+		{
+			name:      "waits for the polling interval",
+			limits:    2,
+			wantPosts: 3,
+			wantWait:  6*time.Second + 2*6*time.Second,
+		},
+		{
+			name:      "gives up after repeated waits",
+			limits:    10,
+			wantErr:   true,
+			wantPosts: 6,
+			wantWait:  6*time.Second + 5*6*time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := oauthtest.NewClock(start)
+			clock.AutoAdvance = true
+			client := &formClient{}
+			for i := 0; i < tt.limits; i++ {
+				client.bodies = append(client.bodies, "")
+				client.statuses = append(client.statuses, http.StatusForbidden)
+				client.headers = append(client.headers, rateLimited)
+			}
+			client.bodies = append(client.bodies, "access_token=123abc")
+
+			_, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+				ClientID: "CLIENT-ID",
+				DeviceCode: &device.CodeResponse{
+					DeviceCode: "DEVIC",
+					ExpiresIn:  900,
+					Interval:   5,
+				},
+				Clock: clock,
+			})
+			var rateLimit *api.RateLimitError
+			if tt.wantErr && !errors.As(err, &rateLimit) {
+				t.Errorf("Wait() error = %v, want *api.RateLimitError", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("Wait() error: %v", err)
+			}
+			if client.posts != tt.wantPosts {
+				t.Errorf("expected %d HTTP POSTs, got %d", tt.wantPosts, client.posts)
+			}
+			if got := clock.Now().Sub(start); got != tt.wantWait {
+				t.Errorf("waited %v, want %v", got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestWait_rateLimitOutlivesCode(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := oauthtest.NewClock(start)
+	clock.AutoAdvance = true
+
+	reset := start.Add(time.Hour)
+	client := &formClient{
+		bodies:   []string{""},
+		statuses: []int{http.StatusForbidden},
+		headers: []http.Header{
+			{
+				"X-Ratelimit-Limit":     {"60"},
+				"X-Ratelimit-Remaining": {"0"},
+				"X-Ratelimit-Reset":     {strconv.FormatInt(reset.Unix(), 10)},
+			},
+		},
+	}
+
+	_, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Clock: clock,
+	})
+	var rateLimit *api.RateLimitError
+	if !errors.As(err, &rateLimit) {
+		t.Fatalf("Wait() error = %v, want *api.RateLimitError", err)
+	}
+	if !rateLimit.Reset.Equal(reset) || rateLimit.Limit != 60 || rateLimit.Remaining != 0 {
+		t.Errorf("RateLimitError = %+v", rateLimit)
+	}
+	if client.posts != 1 {
+		t.Errorf("expected 1 HTTP POST, got %d", client.posts)
+	}
+}
+
+func TestWait_retryBackoff(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := oauthtest.NewClock(start)
+	clock.AutoAdvance = true
+
+	client := &formClient{
+		bodies: []string{
+			"<h1>Bad gateway</h1>",
+			"access_token=123abc",
+		},
+		statuses: []int{http.StatusBadGateway},
+	}
+
+	token, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Retry: device.RetryPolicy{
+			MaxConsecutiveFailures: 1,
+			InitialBackoff:         10 * time.Second,
+		},
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "123abc" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	// The first poll waits 6s, and the retry waits for the backoff of 5s to 10s, but no less than the
+	// polling interval of 6s.
+	if got := clock.Now().Sub(start); got < 12*time.Second || got > 16*time.Second {
+		t.Errorf("waited %v, want between 12s and 16s", got)
+	}
+}
+
+// pacingClient answers like a server that enforces the polling interval: a poll that comes sooner
+// than the interval after the previous one gets "slow_down" and makes the interval 5s longer. Polls
+// that keep the pace get the scripted bodies and statuses in turn.
+type pacingClient struct {
+	clock     device.Clock
+	interval  time.Duration
+	bodies    []string
+	statuses  []int
+	last      time.Time
+	paced     int
+	slowDowns int
+}
+
+func (c *pacingClient) PostForm(string, url.Values) (*http.Response, error) {
+	now := c.clock.Now()
+	early := !c.last.IsZero() && now.Sub(c.last) < c.interval
+	c.last = now
+
+	resp := &http.Response{
+		Header: http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		},
+		StatusCode: 200,
+	}
+	if early {
+		c.slowDowns++
+		c.interval += 5 * time.Second
+		resp.Body = io.NopCloser(strings.NewReader(fmt.Sprintf("error=slow_down&interval=%d", c.interval/time.Second)))
+		return resp, nil
+	}
+
+	i := c.paced
+	c.paced++
+	resp.Body = io.NopCloser(strings.NewReader(c.bodies[i]))
+	if i < len(c.statuses) && c.statuses[i] != 0 {
+		resp.StatusCode = c.statuses[i]
+	}
+	return resp, nil
+}
+
+func TestWait_retryKeepsPace(t *testing.T) {
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.AutoAdvance = true
+
+	client := &pacingClient{
+		clock:    clock,
+		interval: 5 * time.Second,
+		bodies: []string{
+			"<h1>Bad gateway</h1>",
+			"<h1>Bad gateway</h1>",
+			"access_token=123abc",
+		},
+		statuses: []int{http.StatusBadGateway, http.StatusBadGateway},
+	}
+
+	// With the default backoff of at most 1s, retries that ignored the polling interval would be
+	// answered with slow_down twice, which Wait takes for clock drift.
+	token, err := device.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/oauth", device.WaitOptions{
+		ClientID: "CLIENT-ID",
+		DeviceCode: &device.CodeResponse{
+			DeviceCode: "DEVIC",
+			ExpiresIn:  900,
+			Interval:   5,
+		},
+		Retry: device.RetryPolicy{MaxConsecutiveFailures: 2},
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "123abc" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if client.slowDowns != 0 {
+		t.Errorf("got %d slow_down responses, want none", client.slowDowns)
+	}
+}
+
+func TestWait_canceledStopsTimer(t *testing.T) {
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := device.Wait(ctx, api.FromFormPoster(&formClient{}), "https://github.com/oauth", device.WaitOptions{
+			ClientID: "CLIENT-ID",
+			DeviceCode: &device.CodeResponse{
+				DeviceCode: "DEVIC",
+				ExpiresIn:  900,
+				Interval:   5,
+			},
+			Clock: clock,
+		})
+		errc <- err
+	}()
+
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait() error = %v, want context.Canceled", err)
+	}
+	if clock.Pending() != 0 {
+		t.Errorf("Pending() = %d after Wait returned, want 0", clock.Pending())
+	}
+}
@@ -0,0 +1,201 @@
+// Package dockercredential implements a Docker credential helper that provides OAuth access tokens
+// as the secrets for container registries such as ghcr.io, using the protocol described in
+// https://github.com/docker/docker-credential-helpers.
+//
+// A program named docker-credential-<name> that is built on Helper is configured for a registry in
+// ~/.docker/config.json with:
+//
+//	{
+//	  "credHelpers": {
+//	    "ghcr.io": "<name>"
+//	  }
+//	}
+//
+// Docker then runs the program with the "get", "store", "erase" or "list" operation as its argument.
+// Since tokens are obtained and kept by an accounts.Manager, "store" and "erase" leave them as they
+// are: "docker login" and "docker logout" do not log in to or out of the accounts.
+package dockercredential
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+)
+
+// ErrCredentialsNotFound is the error that tells Docker that the helper has no credentials for a
+// registry. Docker recognizes it by its message.
+var ErrCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// Credentials are the credentials for a registry as exchanged with Docker.
+type Credentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// Registry is a container registry that accepts the OAuth tokens of a host.
+type Registry struct {
+	// ServerURL identifies the registry as Docker does, e.g. "ghcr.io".
+	ServerURL string
+	// Host is the host name of the accounts whose tokens the registry accepts, e.g. "github.com".
+	Host string
+	// Flow logs in to Host, or refreshes tokens for it.
+	Flow *oauth.Flow
+}
+
+// Helper answers the requests of Docker for credentials with the access token of the active
+// account on the host of each registry, as kept by an accounts.Manager.
+type Helper struct {
+	// Manager keeps the accounts and their tokens.
+	Manager *accounts.Manager
+	// Registries are the registries that the helper provides credentials for.
+	Registries []Registry
+	// Interactive allows Get to log in when there is no usable token for a registry.
+	Interactive bool
+	// Stderr receives the one-time code and other messages while logging in, since Docker reads the
+	// credentials, or an error message, from stdout. Defaults to os.Stderr.
+	Stderr io.Writer
+}
+
+// Run performs the operation op, as passed by Docker on the command line, reading the request from
+// in and writing the response to out. If the operation fails, the error message is also written to
+// out, where Docker expects it, and the program should exit with a non-zero status.
+func (h *Helper) Run(ctx context.Context, op string, in io.Reader, out io.Writer) error {
+	err := h.run(ctx, op, in, out)
+	if err != nil {
+		fmt.Fprintln(out, err)
+	}
+	return err
+}
+
+func (h *Helper) run(ctx context.Context, op string, in io.Reader, out io.Writer) error {
+	switch op {
+	case "get":
+		serverURL, err := readServerURL(in)
+		if err != nil {
+			return err
+		}
+		creds, err := h.Get(ctx, serverURL)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(out).Encode(creds)
+	case "store":
+		// Tokens are stored when they are obtained, so the credentials of "docker login" are not kept.
+		var creds Credentials
+		return json.NewDecoder(in).Decode(&creds)
+	case "erase":
+		serverURL, err := readServerURL(in)
+		if err != nil {
+			return err
+		}
+		return h.Erase(serverURL)
+	case "list":
+		list, err := h.List()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(out).Encode(list)
+	}
+	return fmt.Errorf("unknown credential action %q", op)
+}
+
+// Get returns the credentials for the registry at serverURL: the login and the access token of the
+// active account on the host of the registry, refreshed if it is about to expire. If there is no
+// usable token and Interactive is set, the user is asked to log in first. It returns
+// ErrCredentialsNotFound if the helper has no credentials for the registry.
+func (h *Helper) Get(ctx context.Context, serverURL string) (*Credentials, error) {
+	registry := h.registry(serverURL)
+	if registry == nil {
+		return nil, ErrCredentialsNotFound
+	}
+
+	stderr := h.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	entry, err := h.Manager.TokenOrLogin(ctx, registry.Host, registry.Flow.WithUIOutput(stderr), h.Interactive)
+	if errors.Is(err, accounts.ErrNotFound) || errors.Is(err, accounts.ErrExpired) {
+		return nil, ErrCredentialsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{
+		ServerURL: serverURL,
+		Username:  entry.Account.Login,
+		Secret:    entry.Token.Token,
+	}, nil
+}
+
+// Erase handles "docker logout" for the registry at serverURL. It does not remove any account,
+// since the accounts are shared with other programs, such as git, and Docker does not say which
+// token it wants erased. Accounts are removed with Manager.Remove instead, e.g. by logging out with
+// the program that manages them. It returns ErrCredentialsNotFound if the helper does not handle the
+// registry.
+func (h *Helper) Erase(serverURL string) error {
+	if h.registry(serverURL) == nil {
+		return ErrCredentialsNotFound
+	}
+	return nil
+}
+
+// List returns the login of the active account for each registry that has one, keyed by the server
+// URL of the registry.
+func (h *Helper) List() (map[string]string, error) {
+	list := make(map[string]string)
+	for _, registry := range h.Registries {
+		entry, err := h.Manager.Active(registry.Host)
+		if errors.Is(err, accounts.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list[registry.ServerURL] = entry.Account.Login
+	}
+	return list, nil
+}
+
+// registry returns the registry for serverURL, or nil if the helper does not handle it.
+func (h *Helper) registry(serverURL string) *Registry {
+	for i := range h.Registries {
+		if normalizeServerURL(h.Registries[i].ServerURL) == normalizeServerURL(serverURL) {
+			return &h.Registries[i]
+		}
+	}
+	return nil
+}
+
+// readServerURL reads the server URL that Docker passes on stdin.
+func readServerURL(in io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(in, 4096))
+	if err != nil {
+		return "", err
+	}
+	serverURL := strings.TrimSpace(string(b))
+	if serverURL == "" {
+		return "", errors.New("no server URL")
+	}
+	return serverURL, nil
+}
+
+// normalizeServerURL returns the registry host of serverURL, which Docker may pass with or without
+// a scheme and a path, e.g. "https://ghcr.io/v2/".
+func normalizeServerURL(serverURL string) string {
+	s := strings.ToLower(strings.TrimSpace(serverURL))
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
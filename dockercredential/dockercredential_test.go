@@ -0,0 +1,90 @@
+package dockercredential
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func TestHelper(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID"})
+	defer s.Close()
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	flow := &oauth.Flow{Host: host, ClientID: "CLIENT-ID", BrowseURL: func(string) error { return nil }}
+	h := &Helper{
+		Manager: accounts.NewManager(&accounts.MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+			return "monalisa", "1", nil
+		}),
+		Registries: []Registry{
+			{ServerURL: "ghcr.io", Host: "github.com", Flow: flow},
+			{ServerURL: "docker.pkg.github.com", Host: "github.com", Flow: flow},
+		},
+		Stderr: &stderr,
+	}
+
+	run := func(op, input string) (string, error) {
+		var out bytes.Buffer
+		err := h.Run(ctx, op, strings.NewReader(input), &out)
+		return out.String(), err
+	}
+
+	if out, err := run("get", "ghcr.io\n"); !errors.Is(err, ErrCredentialsNotFound) || out != "credentials not found in native keychain\n" {
+		t.Errorf("get without logging in: %q, %v", out, err)
+	}
+	if out, err := run("list", ""); err != nil || out != "{}\n" {
+		t.Errorf("list without logging in: %q, %v", out, err)
+	}
+
+	h.Interactive = true
+	out, err := run("get", "https://ghcr.io/v2/\n")
+	if err != nil {
+		t.Fatalf("get error: %v", err)
+	}
+	var creds Credentials
+	if err := json.Unmarshal([]byte(out), &creds); err != nil {
+		t.Fatalf("get output %q: %v", out, err)
+	}
+	if creds.ServerURL != "https://ghcr.io/v2/" || creds.Username != "monalisa" || !s.Valid(creds.Secret) {
+		t.Errorf("get: %+v", creds)
+	}
+	if !strings.Contains(stderr.String(), "To authorize, enter the one-time code ") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	if out, err := run("get", "registry.example.com"); !errors.Is(err, ErrCredentialsNotFound) {
+		t.Errorf("get for another registry: %q, %v", out, err)
+	}
+	if out, err := run("list", ""); err != nil || out != `{"docker.pkg.github.com":"monalisa","ghcr.io":"monalisa"}`+"\n" {
+		t.Errorf("list: %q, %v", out, err)
+	}
+	if out, err := run("store", `{"ServerURL":"ghcr.io","Username":"monalisa","Secret":"ghp_PAT"}`); err != nil || out != "" {
+		t.Errorf("store: %q, %v", out, err)
+	}
+	// The accounts are shared with other programs, so "docker logout" does not remove them.
+	if out, err := run("erase", "ghcr.io"); err != nil || out != "" {
+		t.Errorf("erase: %q, %v", out, err)
+	}
+	if _, err := h.Manager.Active("github.com"); err != nil {
+		t.Errorf("erase removed the account: %v", err)
+	}
+	if out, err := run("erase", "registry.example.com"); !errors.Is(err, ErrCredentialsNotFound) {
+		t.Errorf("erase for another registry: %q, %v", out, err)
+	}
+	if out, err := run("version", ""); err == nil || out != "unknown credential action \"version\"\n" {
+		t.Errorf("unknown action: %q, %v", out, err)
+	}
+}
@@ -0,0 +1,50 @@
+package dockercredential_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/dockercredential"
+)
+
+// The main function of a Docker credential helper that provides GitHub OAuth tokens to ghcr.io.
+func ExampleHelper() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	host, err := oauth.NewGitHubHost("https://github.com")
+	if err != nil {
+		panic(err)
+	}
+	helper := &dockercredential.Helper{
+		Manager: accounts.NewManager(
+			&accounts.FileStore{Path: filepath.Join(configDir, "my-app", "accounts.json")},
+			accounts.GitHubIdentity(http.DefaultClient),
+		),
+		Registries: []dockercredential.Registry{
+			{
+				ServerURL: "ghcr.io",
+				Host:      "github.com",
+				Flow: &oauth.Flow{
+					Host:     host,
+					ClientID: os.Getenv("OAUTH_CLIENT_ID"),
+					Scopes:   []string{"read:packages", "write:packages"},
+				},
+			},
+		},
+		Interactive: true,
+	}
+
+	if len(os.Args) < 2 {
+		os.Exit(2)
+	}
+	// Run reports errors on stdout, as Docker expects.
+	if err := helper.Run(context.Background(), os.Args[1], os.Stdin, os.Stdout); err != nil {
+		os.Exit(1)
+	}
+}
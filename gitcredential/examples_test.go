@@ -0,0 +1,48 @@
+package gitcredential_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/gitcredential"
+)
+
+// The main function of a git credential helper that logs in to ghe.example.com with the app's own
+// client ID, and leaves all other hosts to other helpers.
+func ExampleHelper() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	helper := &gitcredential.Helper{
+		Manager: accounts.NewManager(
+			&accounts.FileStore{Path: filepath.Join(configDir, "my-app", "accounts.json")},
+			accounts.GitHubIdentity(http.DefaultClient),
+		),
+		Flow: func(c *gitcredential.Credential) (*oauth.Flow, error) {
+			if c.Host != "ghe.example.com" {
+				return nil, nil
+			}
+			return &oauth.Flow{
+				ClientID:    os.Getenv("OAUTH_CLIENT_ID"),
+				CallbackURI: "http://127.0.0.1/callback",
+				Scopes:      []string{"repo"},
+			}, nil
+		},
+		Interactive: os.Getenv("GIT_TERMINAL_PROMPT") != "0",
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: my-credential-helper get|store|erase")
+		os.Exit(2)
+	}
+	if err := helper.Run(context.Background(), os.Args[1], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,220 @@
+// Package gitcredential implements a git credential helper that provides OAuth access tokens as the
+// passwords for git over HTTPS, using the protocol described in
+// https://git-scm.com/docs/git-credential.
+//
+// A program built on Helper can be configured as the credential helper for a host with e.g.:
+//
+//	git config --global credential.https://ghe.example.com.helper "/path/to/program"
+//
+// git then runs the program with the "get", "store" or "erase" operation as its argument.
+package gitcredential
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+)
+
+// Credential holds the attributes that git exchanges with credential helpers. Attributes that this
+// package does not know about are ignored.
+type Credential struct {
+	// Protocol is the protocol over which the credential will be used, e.g. "https".
+	Protocol string
+	// Host is the remote host name, including the port if one was specified.
+	Host string
+	// Path is the path of the repository, if git is configured to send it.
+	Path string
+	// Username is the user name of the credential.
+	Username string
+	// Password is the password of the credential, which is an access token for this helper.
+	Password string
+	// PasswordExpiry is when the password expires, or the zero time if it does not.
+	PasswordExpiry time.Time
+}
+
+// Read parses a credential description from r, which ends at a blank line or at the end of input.
+func Read(r io.Reader) (*Credential, error) {
+	c := &Credential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid credential line %q", line)
+		}
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "password_expiry_utc":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid password_expiry_utc %q: %w", value, err)
+			}
+			c.PasswordExpiry = time.Unix(seconds, 0)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write writes the attributes of c that are set to w, in the format that Read parses.
+func (c *Credential) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, attr := range []struct{ key, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	} {
+		if attr.value == "" {
+			continue
+		}
+		if strings.ContainsAny(attr.value, "\n\x00") {
+			return fmt.Errorf("credential %s contains a newline or NUL character", attr.key)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", attr.key, attr.value)
+	}
+	if !c.PasswordExpiry.IsZero() {
+		fmt.Fprintf(&b, "password_expiry_utc=%d\n", c.PasswordExpiry.Unix())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Helper answers the requests of git for credentials with the access tokens of the active account
+// on each host, as kept by an accounts.Manager.
+type Helper struct {
+	// Manager keeps the accounts and their tokens.
+	Manager *accounts.Manager
+	// Flow returns the flow to log in to the host of c with, or to refresh tokens with, e.g. configured
+	// with the client ID of the app registered on that host. If the flow has no Host, it is derived
+	// from the protocol and host of c with oauth.NewGitHubHost. Returning a nil flow means that the
+	// helper does not handle the host, and git will try other helpers.
+	Flow func(c *Credential) (*oauth.Flow, error)
+	// Interactive allows Get to log in with the flow when there is no usable token for the host.
+	// Otherwise, git falls back to other helpers or to prompting for a password.
+	Interactive bool
+	// AllowHTTP lets the helper answer requests for remotes over plain HTTP, which sends the token
+	// unencrypted. By default, only HTTPS requests get a credential.
+	AllowHTTP bool
+	// Stderr receives the one-time code and other messages while logging in, since git reads the
+	// credential from stdout. Defaults to os.Stderr.
+	Stderr io.Writer
+}
+
+// Run performs the operation op, as passed by git on the command line, reading the request from in
+// and writing the response to out. Unknown operations are ignored, as the protocol requires.
+func (h *Helper) Run(ctx context.Context, op string, in io.Reader, out io.Writer) error {
+	c, err := Read(in)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "get":
+		cred, err := h.Get(ctx, c)
+		if err != nil || cred == nil {
+			return err
+		}
+		return cred.Write(out)
+	case "erase":
+		return h.Erase(c)
+	}
+	// Tokens are stored when they are obtained, so there is nothing to do for "store".
+	return nil
+}
+
+// Get returns the username and password for the request c, or nil if the helper has none to offer.
+// The password is the access token of the active account on the host, refreshed if it is about to
+// expire. If there is no usable token and Interactive is set, the user is asked to log in first.
+func (h *Helper) Get(ctx context.Context, c *Credential) (*Credential, error) {
+	flow, err := h.flow(c)
+	if err != nil || flow == nil {
+		return nil, err
+	}
+
+	entry, err := h.Manager.TokenOrLogin(ctx, c.Host, flow, h.Interactive)
+	if errors.Is(err, accounts.ErrNotFound) || errors.Is(err, accounts.ErrExpired) {
+		// git falls back to other helpers or to prompting for a password.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{
+		Username:       entry.Account.Login,
+		Password:       entry.Token.Token,
+		PasswordExpiry: entry.ExpiresAt(),
+	}, nil
+}
+
+// Erase removes the account whose token git reports as rejected in c, so that the next Get does not
+// offer it again.
+func (h *Helper) Erase(c *Credential) error {
+	if c.Password == "" {
+		return nil
+	}
+	list, err := h.Manager.List(c.Host)
+	if err != nil {
+		return err
+	}
+	for _, account := range list {
+		entry, err := h.Manager.Get(account.Host, account.Login)
+		if err != nil {
+			return err
+		}
+		if entry.Token != nil && entry.Token.Token == c.Password {
+			return h.Manager.Remove(account.Host, account.Login)
+		}
+	}
+	return nil
+}
+
+// flow returns the flow for c, with its host derived from c if needed.
+func (h *Helper) flow(c *Credential) (*oauth.Flow, error) {
+	if c.Host == "" || (c.Protocol != "https" && (c.Protocol != "http" || !h.AllowHTTP)) {
+		return nil, nil
+	}
+	flow, err := h.Flow(c)
+	if err != nil || flow == nil {
+		return nil, err
+	}
+
+	if flow.Host == nil {
+		flow.Host, err = oauth.NewGitHubHost(c.Protocol + "://" + c.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stderr := h.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	flow = flow.WithUIOutput(stderr)
+	// Pasting the redirect URL would need stdin, which carries the protocol.
+	flow.ManualWebAppInput = false
+	return flow, nil
+}
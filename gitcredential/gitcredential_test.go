@@ -0,0 +1,185 @@
+package gitcredential
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *Credential
+		wantErr string
+	}{
+		{
+			name:  "request",
+			input: "capability[]=authtype\nprotocol=https\nhost=ghe.example.com:8443\npath=org/repo.git\nwwwauth[]=Basic realm=\"GitHub\"\n\nignored=after blank line\n",
+			want:  &Credential{Protocol: "https", Host: "ghe.example.com:8443", Path: "org/repo.git"},
+		},
+		{
+			name:  "rejected credential with CRLF",
+			input: "protocol=https\r\nhost=github.com\r\nusername=monalisa\r\npassword=gho_a=b\r\npassword_expiry_utc=1714568400\r\n",
+			want: &Credential{
+				Protocol:       "https",
+				Host:           "github.com",
+				Username:       "monalisa",
+				Password:       "gho_a=b",
+				PasswordExpiry: time.Unix(1714568400, 0),
+			},
+		},
+		{
+			name:    "malformed line",
+			input:   "protocol\n",
+			wantErr: `invalid credential line "protocol"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Read() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredential_Write(t *testing.T) {
+	var out bytes.Buffer
+	c := &Credential{Username: "monalisa", Password: "gho_TOKEN", PasswordExpiry: time.Unix(1714568400, 0)}
+	if err := c.Write(&out); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if got, want := out.String(), "username=monalisa\npassword=gho_TOKEN\npassword_expiry_utc=1714568400\n"; got != want {
+		t.Errorf("Write() wrote %q, want %q", got, want)
+	}
+
+	c = &Credential{Username: "mona\nhost=evil.example.com"}
+	if err := c.Write(&out); err == nil {
+		t.Error("expected an error for a value with a newline")
+	}
+}
+
+func TestHelper(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID", TokenExpiresIn: 3600})
+	defer s.Close()
+	serverURL, _ := url.Parse(s.URL)
+	request := "protocol=http\nhost=" + serverURL.Host + "\n"
+
+	var stderr bytes.Buffer
+	h := &Helper{
+		Manager: accounts.NewManager(&accounts.MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+			return "monalisa", "1", nil
+		}),
+		Flow: func(c *Credential) (*oauth.Flow, error) {
+			if c.Host != serverURL.Host {
+				return nil, nil
+			}
+			return &oauth.Flow{ClientID: "CLIENT-ID", BrowseURL: func(string) error { return nil }}, nil
+		},
+		AllowHTTP: true,
+		Stderr:    &stderr,
+	}
+
+	run := func(op, input string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := h.Run(ctx, op, strings.NewReader(input), &out); err != nil {
+			t.Fatalf("Run(%q) error: %v", op, err)
+		}
+		return out.String()
+	}
+
+	if out := run("get", request); out != "" {
+		t.Errorf("get without logging in: %q", out)
+	}
+
+	h.Interactive = true
+	out := run("get", request)
+	cred, err := Read(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if cred.Username != "monalisa" || !s.Valid(cred.Password) || cred.PasswordExpiry.IsZero() {
+		t.Errorf("get: %q", out)
+	}
+	if !strings.Contains(stderr.String(), "To authorize, enter the one-time code ") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	// The stored token is reused without logging in again.
+	h.Interactive = false
+	if again := run("get", request); again != out {
+		t.Errorf("second get: %q, want %q", again, out)
+	}
+	if out := run("get", "protocol=https\nhost=github.com\n"); out != "" {
+		t.Errorf("get for an unconfigured host: %q", out)
+	}
+	if out := run("get", "protocol=ssh\nhost="+serverURL.Host+"\n"); out != "" {
+		t.Errorf("get over ssh: %q", out)
+	}
+
+	run("store", request+"username=monalisa\npassword="+cred.Password+"\n")
+	run("erase", request+"username=monalisa\npassword=other\n")
+	if _, err := h.Manager.Active(serverURL.Host); err != nil {
+		t.Errorf("erasing another password removed the account: %v", err)
+	}
+	run("erase", request+"username=monalisa\npassword="+cred.Password+"\n")
+	if out := run("get", request); out != "" {
+		t.Errorf("get after erase: %q", out)
+	}
+}
+
+func TestHelper_http(t *testing.T) {
+	ctx := context.Background()
+	m := accounts.NewManager(&accounts.MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+		return "monalisa", "1", nil
+	})
+	if _, err := m.Add(ctx, "ghe.example.com", &api.AccessToken{Token: "TOKEN"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	h := &Helper{
+		Manager: m,
+		Flow: func(c *Credential) (*oauth.Flow, error) {
+			return &oauth.Flow{ClientID: "CLIENT-ID", BrowseURL: func(string) error { return nil }}, nil
+		},
+		Interactive: true,
+		Stderr:      &stderr,
+	}
+	request := &Credential{Protocol: "http", Host: "ghe.example.com"}
+
+	// The token would be sent unencrypted, so the helper neither offers it nor logs in.
+	if cred, err := h.Get(ctx, request); err != nil || cred != nil {
+		t.Errorf("Get() over http = %+v, %v; want no credential", cred, err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	h.AllowHTTP = true
+	if cred, err := h.Get(ctx, request); err != nil || cred == nil || cred.Password != "TOKEN" {
+		t.Errorf("Get() over http with AllowHTTP = %+v, %v", cred, err)
+	}
+}
@@ -0,0 +1,42 @@
+package kubecredential_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/kubecredential"
+)
+
+// The main function of a credential plugin for clusters whose gateways accept GitHub OAuth tokens.
+func ExamplePlugin() {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	host, err := oauth.NewGitHubHost("https://github.com")
+	if err != nil {
+		panic(err)
+	}
+	plugin := &kubecredential.Plugin{
+		Manager: accounts.NewManager(
+			&accounts.FileStore{Path: filepath.Join(configDir, "my-app", "accounts.json")},
+			accounts.GitHubIdentity(http.DefaultClient),
+		),
+		Host: "github.com",
+		Flow: &oauth.Flow{
+			Host:     host,
+			ClientID: os.Getenv("OAUTH_CLIENT_ID"),
+			Scopes:   []string{"read:org"},
+		},
+	}
+
+	if err := plugin.Run(context.Background(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,159 @@
+// Package kubecredential implements a Kubernetes client-go credential plugin that authenticates to
+// clusters with OAuth access tokens, as described in
+// https://kubernetes.io/docs/reference/access-authn-authz/authentication/#client-go-credential-plugins.
+//
+// A program built on Plugin is configured in a kubeconfig user entry such as:
+//
+//	users:
+//	- name: github
+//	  user:
+//	    exec:
+//	      apiVersion: client.authentication.k8s.io/v1
+//	      command: /path/to/program
+//	      interactiveMode: IfAvailable
+package kubecredential
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+)
+
+// The API versions of ExecCredential that a Plugin can respond with.
+const (
+	APIVersionV1      = "client.authentication.k8s.io/v1"
+	APIVersionV1beta1 = "client.authentication.k8s.io/v1beta1"
+)
+
+// ExecInfoEnv is the environment variable in which client-go passes an ExecCredential with the
+// request details to the plugin.
+const ExecInfoEnv = "KUBERNETES_EXEC_INFO"
+
+// ExecCredential is the document that client-go and a credential plugin exchange.
+type ExecCredential struct {
+	APIVersion string  `json:"apiVersion"`
+	Kind       string  `json:"kind"`
+	Spec       Spec    `json:"spec"`
+	Status     *Status `json:"status,omitempty"`
+}
+
+// Spec holds the request details passed to the plugin.
+type Spec struct {
+	// Cluster describes the cluster being authenticated to, if the kubeconfig asks for it with
+	// provideClusterInfo.
+	Cluster *Cluster `json:"cluster,omitempty"`
+	// Interactive reports whether the plugin may interact with the user through stdin.
+	Interactive bool `json:"interactive"`
+}
+
+// Cluster describes the cluster that a credential is requested for.
+type Cluster struct {
+	Server                   string          `json:"server"`
+	TLSServerName            string          `json:"tls-server-name,omitempty"`
+	InsecureSkipTLSVerify    bool            `json:"insecure-skip-tls-verify,omitempty"`
+	CertificateAuthorityData []byte          `json:"certificate-authority-data,omitempty"`
+	ProxyURL                 string          `json:"proxy-url,omitempty"`
+	Config                   json.RawMessage `json:"config,omitempty"`
+}
+
+// Status holds the credential returned by the plugin.
+type Status struct {
+	// ExpirationTimestamp is when the token expires. client-go runs the plugin again after that.
+	ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+	// Token is the bearer token to authenticate with.
+	Token string `json:"token,omitempty"`
+}
+
+// ReadExecInfo returns the ExecCredential that client-go passed in the ExecInfoEnv variable looked up
+// with getenv, or nil if the variable is not set, as with older versions of client-go.
+func ReadExecInfo(getenv func(string) string) (*ExecCredential, error) {
+	info := getenv(ExecInfoEnv)
+	if info == "" {
+		return nil, nil
+	}
+	var cred ExecCredential
+	if err := json.Unmarshal([]byte(info), &cred); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ExecInfoEnv, err)
+	}
+	if cred.Kind != "ExecCredential" {
+		return nil, fmt.Errorf("parsing %s: unexpected kind %q", ExecInfoEnv, cred.Kind)
+	}
+	return &cred, nil
+}
+
+// Plugin provides the access token of the active account on a host as an ExecCredential.
+type Plugin struct {
+	// Manager keeps the accounts and their tokens.
+	Manager *accounts.Manager
+	// Host is the host name of the accounts to use, e.g. "github.com".
+	Host string
+	// Flow logs in to Host, or refreshes tokens for it.
+	Flow *oauth.Flow
+	// Interactive allows logging in with Flow when there is no usable token and client-go did not
+	// say whether the user can interact with the plugin. If it did, its decision takes precedence.
+	Interactive bool
+	// Getenv looks up environment variables. Defaults to os.Getenv.
+	Getenv func(string) string
+	// Stderr receives the one-time code and other messages while logging in, since client-go reads
+	// the ExecCredential from stdout and passes stderr through to the user. Defaults to os.Stderr.
+	Stderr io.Writer
+}
+
+// Credential returns the ExecCredential for the active account on Host, refreshing its token if it
+// is about to expire, or logging in if there is no usable token and interaction is allowed.
+func (p *Plugin) Credential(ctx context.Context) (*ExecCredential, error) {
+	getenv := p.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	info, err := ReadExecInfo(getenv)
+	if err != nil {
+		return nil, err
+	}
+	apiVersion, interactive := APIVersionV1, p.Interactive
+	if info != nil {
+		interactive = info.Spec.Interactive
+		if info.APIVersion == APIVersionV1beta1 {
+			apiVersion = info.APIVersion
+		}
+	}
+
+	stderr := p.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	entry, err := p.Manager.TokenOrLogin(ctx, p.Host, p.Flow.WithUIOutput(stderr), interactive)
+	if errors.Is(err, accounts.ErrNotFound) || errors.Is(err, accounts.ErrExpired) {
+		return nil, fmt.Errorf("not logged in to %s: %w", p.Host, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Token: entry.Token.Token}
+	if expiresAt := entry.ExpiresAt(); !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC().Truncate(time.Second)
+		status.ExpirationTimestamp = &expiresAt
+	}
+	return &ExecCredential{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status:     status,
+	}, nil
+}
+
+// Run writes the ExecCredential for the active account on Host to out as JSON.
+func (p *Plugin) Run(ctx context.Context, out io.Writer) error {
+	cred, err := p.Credential(ctx)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(out).Encode(cred)
+}
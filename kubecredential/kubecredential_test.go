@@ -0,0 +1,146 @@
+package kubecredential
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/accounts"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func TestReadExecInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    *ExecCredential
+		wantErr string
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name: "v1 with cluster info",
+			env:  `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com","config":{"audience":"gateway"}},"interactive":true}}`,
+			want: &ExecCredential{
+				APIVersion: APIVersionV1,
+				Kind:       "ExecCredential",
+				Spec: Spec{
+					Cluster:     &Cluster{Server: "https://k8s.example.com", Config: json.RawMessage(`{"audience":"gateway"}`)},
+					Interactive: true,
+				},
+			},
+		},
+		{
+			name:    "malformed",
+			env:     `{"kind":`,
+			wantErr: "parsing KUBERNETES_EXEC_INFO: unexpected end of JSON input",
+		},
+		{
+			name:    "wrong kind",
+			env:     `{"kind":"Pod"}`,
+			wantErr: `parsing KUBERNETES_EXEC_INFO: unexpected kind "Pod"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadExecInfo(func(string) string { return tt.env })
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ReadExecInfo() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadExecInfo() error: %v", err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("ReadExecInfo() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestPlugin_Run(t *testing.T) {
+	ctx := context.Background()
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID", TokenExpiresIn: 3600})
+	defer s.Close()
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var execInfo string
+	var stderr bytes.Buffer
+	p := &Plugin{
+		Manager: accounts.NewManager(&accounts.MemoryStore{}, func(context.Context, string, *api.AccessToken) (string, string, error) {
+			return "monalisa", "1", nil
+		}),
+		Host: "github.com",
+		Flow: &oauth.Flow{
+			Host:        host,
+			ClientID:    "CLIENT-ID",
+			BrowseURL:   func(string) error { return nil },
+			DisplayCode: func(string, string) error { return nil },
+		},
+		Getenv: func(k string) string {
+			if k == ExecInfoEnv {
+				return execInfo
+			}
+			return ""
+		},
+		Stderr: &stderr,
+	}
+
+	// Without exec info, Interactive decides.
+	var out bytes.Buffer
+	if err := p.Run(ctx, &out); err == nil || !strings.Contains(err.Error(), "not logged in to github.com") {
+		t.Fatalf("Run() error = %v", err)
+	}
+	execInfo = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`
+	p.Interactive = true
+	if err := p.Run(ctx, &out); err == nil {
+		t.Fatal("Run() logged in although client-go disallowed interaction")
+	}
+
+	execInfo = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":true}}`
+	start := time.Now()
+	if err := p.Run(ctx, &out); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	var cred struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Status     struct {
+			Token               string `json:"token"`
+			ExpirationTimestamp string `json:"expirationTimestamp"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &cred); err != nil {
+		t.Fatalf("output %q: %v", out.String(), err)
+	}
+	if cred.APIVersion != APIVersionV1 || cred.Kind != "ExecCredential" || !s.Valid(cred.Status.Token) {
+		t.Errorf("output = %s", out.String())
+	}
+	expiresAt, err := time.Parse(time.RFC3339, cred.Status.ExpirationTimestamp)
+	if err != nil || expiresAt.Before(start.Add(59*time.Minute)) || expiresAt.After(start.Add(61*time.Minute)) {
+		t.Errorf("expirationTimestamp = %q", cred.Status.ExpirationTimestamp)
+	}
+
+	// Older clients get the version they asked for.
+	execInfo = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{}}`
+	out.Reset()
+	if err := p.Run(ctx, &out); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(out.String(), `"apiVersion":"client.authentication.k8s.io/v1beta1"`) {
+		t.Errorf("output = %s", out.String())
+	}
+}
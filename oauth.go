@@ -3,26 +3,29 @@
 package oauth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/cli/oauth/api"
 	"github.com/cli/oauth/device"
+	"github.com/cli/oauth/webapp"
 )
 
-type httpClient interface {
-	PostForm(string, url.Values) (*http.Response, error)
-}
-
 // Host defines the endpoints used to authorize against an OAuth server.
 type Host struct {
 	DeviceCodeURL string
 	AuthorizeURL  string
 	TokenURL      string
+	// RevocationURL is the endpoint for revoking tokens as described in RFC 7009. Optional: GitHub
+	// does not implement it.
+	RevocationURL string
 }
 
 // NewGitHubHost constructs a Host from the given URL to a GitHub instance.
@@ -70,12 +73,27 @@ type Flow struct {
 	Scopes []string
 	// OAuth audience to request from the user.
 	Audience string
+	// OpenID Connect parameters, such as a login hint or prompt, to send with the authorization
+	// request. Web application flow sends all of them with the browser URL. Device flow sends only
+	// ACRValues, Claims and Nonce with the code request, since the others concern the sign-in page.
+	OIDC api.OIDCParams
 	// OAuth application ID.
 	ClientID string
 	// OAuth application secret. Only applicable in web application flow.
 	ClientSecret string
 	// The localhost URI for web application flow callback, e.g. "http://127.0.0.1/callback".
 	CallbackURI string
+	// The number of times a new one-time code is requested after the previous one has expired. Only
+	// applicable in Device flow. Defaults to 0, i.e. the flow fails with device.ErrTimeout on expiry.
+	MaxCodeRenewals int
+	// How to retry transient failures while polling for the access token in Device flow. Defaults to no retries.
+	PollRetry device.RetryPolicy
+	// How long Refresh and Revoke may wait for a rate limit to reset before trying the request again.
+	// Defaults to 0, i.e. they fail with *api.RateLimitError.
+	MaxRateLimitWait time.Duration
+	// The source of time for polling in Device flow and for waiting on rate limits. Defaults to the
+	// system clock; tests can substitute a fake.
+	Clock device.Clock
 
 	// Display a one-time code to the user. Receives the code and the browser URL as arguments. Defaults to printing the
 	// code to the user on Stdout with instructions to copy the code and to press Enter to continue in their browser.
@@ -85,20 +103,93 @@ type Flow struct {
 	// Render an HTML page to the user upon completion of web application flow. The default is to
 	// render a simple message that informs the user they can close the browser tab and return to the app.
 	WriteSuccessHTML func(io.Writer)
+	// Whether web application flow should also ask the user to paste the URL that their browser was
+	// redirected to, as a fallback for when the browser runs on a different machine than the app, e.g.
+	// over SSH. The prompt is printed to Stdout and the URL is read from Stdin. If the flow completes
+	// through the local server instead, a read from Stdin may still be pending and consume the line
+	// typed next, so Stdin should be dedicated to the flow if the app reads it afterwards.
+	ManualWebAppInput bool
+	// Templates for the HTML pages rendered to the user in web application flow, such as on success or
+	// denial, and static assets to serve alongside them. Takes precedence over WriteSuccessHTML.
+	Pages *webapp.Pages
 
-	// The HTTP client to use for API POST requests. Defaults to http.DefaultClient.
-	HTTPClient httpClient
+	// The HTTP client to use for API POST requests. Defaults to http.DefaultClient. Clients that only
+	// implement PostForm can be adapted using api.FromFormPoster.
+	HTTPClient api.Doer
+	// Options applied to every API request, such as the User-Agent header.
+	RequestOptions api.RequestOptions
+	// Set additional parameters, e.g. provider-specific ones, on the authorization request: the
+	// browser URL in web application flow and the code request in Device flow.
+	AuthRequestEditors []api.AuthRequestEditorFn
+	// Set additional parameters on the token request in either flow.
+	TokenRequestEditors []api.AuthRequestEditorFn
 	// The stream to listen to keyboard input on. Defaults to os.Stdin.
 	Stdin io.Reader
 	// The stream to print UI messages to. Defaults to os.Stdout.
 	Stdout io.Writer
+	// The logger that receives debug records for HTTP exchanges, polling decisions, local server events and
+	// browser launches. Secret values such as tokens and codes are redacted. Defaults to no logging.
+	Logger *slog.Logger
+}
+
+// resolveHost returns Host, or the Host for Hostname if Host is not set.
+func (oa *Flow) resolveHost() (*Host, error) {
+	if oa.Host != nil {
+		return oa.Host, nil
+	}
+	host, err := NewGitHubHost("https://" + oa.Hostname)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the hostname '%s': %w", oa.Hostname, err)
+	}
+	return host, nil
+}
+
+// httpClient returns HTTPClient, or http.DefaultClient if it is not set.
+func (oa *Flow) httpClient() api.Doer {
+	if oa.HTTPClient != nil {
+		return oa.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// requestClient returns httpClient, logging requests to Logger. The device and webapp packages log
+// the requests of their Wait functions themselves, so those get httpClient instead.
+func (oa *Flow) requestClient() api.Doer {
+	c := oa.httpClient()
+	if oa.Logger != nil {
+		c = &api.LoggingClient{Client: c, Logger: oa.Logger}
+	}
+	return c
 }
 
 // DetectFlow tries to perform Device flow first and falls back to Web application flow.
 func (oa *Flow) DetectFlow() (*api.AccessToken, error) {
-	accessToken, err := oa.DeviceFlow()
+	return oa.DetectFlowContext(context.Background())
+}
+
+// DetectFlowContext is like DetectFlow, but aborts the flow and any request in progress when ctx is done.
+func (oa *Flow) DetectFlowContext(ctx context.Context) (*api.AccessToken, error) {
+	accessToken, err := oa.DeviceFlowContext(ctx)
 	if errors.Is(err, device.ErrUnsupported) {
-		return oa.WebAppFlow()
+		return oa.WebAppFlowContext(ctx)
 	}
 	return accessToken, err
 }
+
+// WithUIOutput returns a copy of the flow that prints its messages to w instead of Stdout, and shows
+// the one-time code of Device flow without waiting for Enter on Stdin. This suits programs whose
+// stdin and stdout are reserved for a protocol, such as credential helpers. DisplayCode and Stdout
+// are kept if they are set.
+func (oa *Flow) WithUIOutput(w io.Writer) *Flow {
+	flow := *oa
+	if flow.Stdout == nil {
+		flow.Stdout = w
+	}
+	if flow.DisplayCode == nil {
+		flow.DisplayCode = func(code, verificationURL string) error {
+			_, err := fmt.Fprintf(w, "To authorize, enter the one-time code %s at %s\n", code, verificationURL)
+			return err
+		}
+	}
+	return &flow
+}
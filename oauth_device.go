@@ -3,9 +3,9 @@ package oauth
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 
 	"github.com/cli/browser"
@@ -16,11 +16,11 @@ import (
 // DeviceFlow captures the full OAuth Device flow, including prompting the user to copy a one-time
 // code and opening their web browser, and returns an access token upon completion.
 func (oa *Flow) DeviceFlow() (*api.AccessToken, error) {
-	httpClient := oa.HTTPClient
-	if httpClient == nil {
-		httpClient = http.DefaultClient
-	}
+	return oa.DeviceFlowContext(context.Background())
+}
 
+// DeviceFlowContext is like DeviceFlow, but aborts the flow and any request in progress when ctx is done.
+func (oa *Flow) DeviceFlowContext(ctx context.Context) (*api.AccessToken, error) {
 	stdin := oa.Stdin
 	if stdin == nil {
 		stdin = os.Stdin
@@ -30,45 +30,69 @@ func (oa *Flow) DeviceFlow() (*api.AccessToken, error) {
 		stdout = os.Stdout
 	}
 
-	host := oa.Host
-	if host == nil {
-		parsedHost, err := NewGitHubHost("https://" + oa.Hostname)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the hostname '%s': %w", oa.Hostname, err)
-		}
-		host = parsedHost
-	}
-
-	code, err := device.RequestCode(httpClient, host.DeviceCodeURL,
-		oa.ClientID, oa.Scopes, device.WithAudience(oa.Audience))
+	host, err := oa.resolveHost()
 	if err != nil {
 		return nil, err
 	}
 
-	if oa.DisplayCode == nil {
-		fmt.Fprintf(stdout, "First, copy your one-time code: %s\n", code.UserCode)
-		fmt.Fprint(stdout, "Then press [Enter] to continue in the web browser... ")
-		_ = waitForEnter(stdin)
-	} else {
-		err := oa.DisplayCode(code.UserCode, code.VerificationURI)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	browseURL := oa.BrowseURL
 	if browseURL == nil {
 		browseURL = browser.OpenURL
 	}
 
-	if err = browseURL(code.VerificationURI); err != nil {
-		return nil, fmt.Errorf("error opening the web browser: %w", err)
-	}
+	logger := api.RedactLogger(oa.Logger)
+
+	// The parameters that steer the sign-in page don't apply to the code request, since the user opens
+	// the page on their own; only those that shape the issued tokens are sent.
+	editors := append([]api.AuthRequestEditorFn{
+		device.WithAudience(oa.Audience),
+		api.WithOIDCParams(api.OIDCParams{
+			ACRValues: oa.OIDC.ACRValues,
+			Claims:    oa.OIDC.Claims,
+			Nonce:     oa.OIDC.Nonce,
+		}),
+	}, oa.AuthRequestEditors...)
+	for renewals := 0; ; renewals++ {
+		code, err := device.RequestCodeContext(ctx, oa.requestClient(), host.DeviceCodeURL,
+			oa.ClientID, oa.Scopes, oa.RequestOptions, editors...)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("received one-time code", "response", code)
 
-	return device.Wait(context.TODO(), httpClient, host.TokenURL, device.WaitOptions{
-		ClientID:   oa.ClientID,
-		DeviceCode: code,
-	})
+		if oa.DisplayCode == nil {
+			if renewals > 0 {
+				fmt.Fprintln(stdout, "Your one-time code has expired, so a new one was issued.")
+			}
+			fmt.Fprintf(stdout, "First, copy your one-time code: %s\n", code.UserCode)
+			fmt.Fprint(stdout, "Then press [Enter] to continue in the web browser... ")
+			_ = waitForEnter(stdin)
+		} else {
+			err := oa.DisplayCode(code.UserCode, code.VerificationURI)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		logger.Debug("opening web browser", "url", code.VerificationURI)
+		if err = browseURL(code.VerificationURI); err != nil {
+			return nil, fmt.Errorf("error opening the web browser: %w", err)
+		}
+
+		token, err := device.Wait(ctx, oa.httpClient(), host.TokenURL, device.WaitOptions{
+			ClientID:       oa.ClientID,
+			DeviceCode:     code,
+			Retry:          oa.PollRetry,
+			Clock:          oa.Clock,
+			Logger:         oa.Logger,
+			RequestOptions: oa.RequestOptions,
+			RequestEditors: oa.TokenRequestEditors,
+		})
+		if errors.Is(err, device.ErrTimeout) && renewals < oa.MaxCodeRenewals {
+			continue
+		}
+		return token, err
+	}
 }
 
 func waitForEnter(r io.Reader) error {
@@ -0,0 +1,257 @@
+package oauth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/device"
+)
+
+type apiStub struct {
+	status      int
+	body        string
+	contentType string
+	header      http.Header
+}
+
+type postArgs struct {
+	url    string
+	params url.Values
+}
+
+type apiClient struct {
+	stubs []apiStub
+	calls []postArgs
+
+	postCount int
+}
+
+func (c *apiClient) PostForm(u string, params url.Values) (*http.Response, error) {
+	stub := c.stubs[c.postCount]
+	c.calls = append(c.calls, postArgs{url: u, params: params})
+	c.postCount++
+	header := http.Header{
+		"Content-Type": {stub.contentType},
+	}
+	for k, vs := range stub.header {
+		header[k] = vs
+	}
+	return &http.Response{
+		Body:       io.NopCloser(bytes.NewBufferString(stub.body)),
+		Header:     header,
+		StatusCode: stub.status,
+	}, nil
+}
+
+func codeStub(deviceCode, userCode string) apiStub {
+	return apiStub{
+		body:        "verification_uri=http://verify.me&interval=0&expires_in=99&device_code=" + deviceCode + "&user_code=" + userCode,
+		status:      200,
+		contentType: "application/x-www-form-urlencoded; charset=utf-8",
+	}
+}
+
+var expiredStub = apiStub{
+	body:        "error=expired_token",
+	status:      200,
+	contentType: "application/x-www-form-urlencoded; charset=utf-8",
+}
+
+func TestFlow_DeviceFlow_renewsExpiredCode(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			codeStub("DEVIC-1", "111-aaa"),
+			expiredStub,
+			codeStub("DEVIC-2", "222-bbb"),
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+		},
+	}
+
+	var displayed, browsed []string
+	flow := &Flow{
+		Host: &Host{
+			DeviceCodeURL: "https://github.com/login/device/code",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+		},
+		ClientID:        "CLIENT-ID",
+		MaxCodeRenewals: 1,
+		HTTPClient:      api.FromFormPoster(client),
+		DisplayCode: func(code, _ string) error {
+			displayed = append(displayed, code)
+			return nil
+		},
+		BrowseURL: func(u string) error {
+			browsed = append(browsed, u)
+			return nil
+		},
+	}
+
+	token, err := flow.DeviceFlow()
+	if err != nil {
+		t.Fatalf("DeviceFlow() error: %v", err)
+	}
+	if token.Token != "ATOKEN" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if len(displayed) != 2 || displayed[0] != "111-aaa" || displayed[1] != "222-bbb" {
+		t.Errorf("displayed codes = %v", displayed)
+	}
+	if len(browsed) != 2 {
+		t.Errorf("browsed %d times, want 2", len(browsed))
+	}
+	if got := client.calls[3].params.Get("device_code"); got != "DEVIC-2" {
+		t.Errorf("polled with device_code %q, want %q", got, "DEVIC-2")
+	}
+}
+
+func TestFlow_DeviceFlow_renewalsExhausted(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			codeStub("DEVIC-1", "111-aaa"),
+			expiredStub,
+			codeStub("DEVIC-2", "222-bbb"),
+			expiredStub,
+		},
+	}
+
+	stdout := &bytes.Buffer{}
+	flow := &Flow{
+		Host: &Host{
+			DeviceCodeURL: "https://github.com/login/device/code",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+		},
+		ClientID:        "CLIENT-ID",
+		MaxCodeRenewals: 1,
+		HTTPClient:      api.FromFormPoster(client),
+		BrowseURL:       func(string) error { return nil },
+		Stdin:           bytes.NewBufferString("\n\n"),
+		Stdout:          stdout,
+	}
+
+	_, err := flow.DeviceFlow()
+	if !errors.Is(err, device.ErrTimeout) {
+		t.Fatalf("DeviceFlow() error = %v, want ErrTimeout", err)
+	}
+	if client.postCount != 4 {
+		t.Errorf("expected 4 HTTP POSTs, got %d", client.postCount)
+	}
+	if !bytes.Contains(stdout.Bytes(), []byte("a new one was issued")) {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+}
+
+func TestFlow_DeviceFlow_requestEditors(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			codeStub("DEVIC-1", "111-aaa"),
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+		},
+	}
+
+	flow := &Flow{
+		Host: &Host{
+			DeviceCodeURL: "https://github.com/login/device/code",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+		},
+		ClientID:            "CLIENT-ID",
+		Audience:            "https://api.github.com",
+		OIDC:                api.OIDCParams{LoginHint: "monalisa", Prompt: "login", Nonce: "NONCE"},
+		HTTPClient:          api.FromFormPoster(client),
+		DisplayCode:         func(string, string) error { return nil },
+		BrowseURL:           func(string) error { return nil },
+		AuthRequestEditors:  []api.AuthRequestEditorFn{api.WithParam("acr_values", "phr")},
+		TokenRequestEditors: []api.AuthRequestEditorFn{api.WithParam("resource", "https://api.github.com")},
+	}
+
+	if _, err := flow.DeviceFlow(); err != nil {
+		t.Fatalf("DeviceFlow() error: %v", err)
+	}
+	if got := client.calls[0].params.Encode(); got != "acr_values=phr&audience=https%3A%2F%2Fapi.github.com&client_id=CLIENT-ID&nonce=NONCE&scope=" {
+		t.Errorf("code request params: %s", got)
+	}
+	if got := client.calls[1].params.Get("resource"); got != "https://api.github.com" {
+		t.Errorf("token request resource = %q", got)
+	}
+}
+
+func TestFlow_WithUIOutput(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			codeStub("DEVIC-1", "111-aaa"),
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+		},
+	}
+	flow := &Flow{
+		Host: &Host{
+			DeviceCodeURL: "https://github.com/login/device/code",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+		},
+		ClientID:   "CLIENT-ID",
+		HTTPClient: api.FromFormPoster(client),
+		BrowseURL:  func(string) error { return nil },
+	}
+
+	stderr := &bytes.Buffer{}
+	// The code is shown without waiting for Enter on Stdin.
+	if _, err := flow.WithUIOutput(stderr).DeviceFlow(); err != nil {
+		t.Fatalf("DeviceFlow() error: %v", err)
+	}
+	if got, want := stderr.String(), "To authorize, enter the one-time code 111-aaa at http://verify.me\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if flow.Stdout != nil || flow.DisplayCode != nil {
+		t.Error("WithUIOutput() modified the original flow")
+	}
+}
+
+func TestFlow_DeviceFlow_logging(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			codeStub("DEVIC-1", "111-aaa"),
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+		},
+	}
+	buf := &bytes.Buffer{}
+	flow := &Flow{
+		Host: &Host{
+			DeviceCodeURL: "https://github.com/login/device/code",
+			TokenURL:      "https://github.com/login/oauth/access_token",
+		},
+		ClientID:    "CLIENT-ID",
+		HTTPClient:  api.FromFormPoster(client),
+		DisplayCode: func(string, string) error { return nil },
+		BrowseURL:   func(string) error { return nil },
+		Logger:      slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	if _, err := flow.DeviceFlow(); err != nil {
+		t.Fatalf("DeviceFlow() error: %v", err)
+	}
+	// Each of the two requests is logged once.
+	if got := strings.Count(buf.String(), `msg="HTTP exchange"`); got != 2 {
+		t.Errorf("logged %d HTTP exchanges, want 2:\n%s", got, buf.String())
+	}
+}
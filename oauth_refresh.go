@@ -0,0 +1,127 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/device"
+)
+
+// ErrRevocationUnsupported is returned by Revoke when the Host has no RevocationURL.
+var ErrRevocationUnsupported = errors.New("token revocation not supported")
+
+// Refresh exchanges a refresh token for a new access token, which typically comes with a new
+// refresh token as well. If the server rejects the request due to rate limiting, Refresh waits for
+// the limit to reset and tries again if that is within MaxRateLimitWait. Otherwise, the error is an
+// *api.RateLimitError that tells when to try again.
+func (oa *Flow) Refresh(refreshToken string) (*api.AccessToken, error) {
+	return oa.RefreshContext(context.Background(), refreshToken)
+}
+
+// RefreshContext is like Refresh, but aborts the request when ctx is done.
+func (oa *Flow) RefreshContext(ctx context.Context, refreshToken string) (*api.AccessToken, error) {
+	host, err := oa.resolveHost()
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{
+		"client_id":     {oa.ClientID},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if oa.ClientSecret != "" {
+		values.Set("client_secret", oa.ClientSecret)
+	}
+	api.EditParams(values, oa.TokenRequestEditors...)
+
+	resp, err := oa.postForm(ctx, host.TokenURL, values)
+	if err != nil {
+		return nil, err
+	}
+	return resp.AccessToken()
+}
+
+// Revoke asks the server to invalidate token, which can be an access token or a refresh token, as
+// described in RFC 7009. It returns ErrRevocationUnsupported if the Host has no RevocationURL. Rate
+// limits are handled as in Refresh.
+func (oa *Flow) Revoke(token string) error {
+	return oa.RevokeContext(context.Background(), token)
+}
+
+// RevokeContext is like Revoke, but aborts the request when ctx is done.
+func (oa *Flow) RevokeContext(ctx context.Context, token string) error {
+	host, err := oa.resolveHost()
+	if err != nil {
+		return err
+	}
+	if host.RevocationURL == "" {
+		return ErrRevocationUnsupported
+	}
+
+	values := url.Values{
+		"client_id": {oa.ClientID},
+		"token":     {token},
+	}
+	if oa.ClientSecret != "" {
+		values.Set("client_secret", oa.ClientSecret)
+	}
+
+	resp, err := oa.postForm(ctx, host.RevocationURL, values)
+	if err != nil {
+		return err
+	}
+	// As per RFC 7009, the server responds with 200 even if the token was invalid to begin with.
+	if resp.StatusCode != http.StatusOK {
+		return resp.Err()
+	}
+	return nil
+}
+
+// maxRateLimitRetries bounds the number of times postForm tries a request again after waiting for a
+// rate limit to reset, in case the server keeps rejecting it.
+const maxRateLimitRetries = 3
+
+// minRateLimitWait is the least time that postForm waits for a rate limit to reset, in case the
+// reset time has already passed due to clock skew.
+const minRateLimitWait = time.Second
+
+// postForm posts values to uri. If the server rejects the request due to rate limiting and the limit
+// resets within MaxRateLimitWait, it waits for the reset and tries again.
+func (oa *Flow) postForm(ctx context.Context, uri string, values url.Values) (*api.FormResponse, error) {
+	clock := oa.Clock
+	if clock == nil {
+		clock = device.SystemClock()
+	}
+	logger := api.RedactLogger(oa.Logger)
+	deadline := clock.Now().Add(oa.MaxRateLimitWait)
+
+	for retries := 0; ; retries++ {
+		resp, err := api.PostForm(ctx, oa.requestClient(), uri, values, oa.RequestOptions)
+		if err != nil {
+			return nil, err
+		}
+		rateLimit := resp.RateLimit(clock.Now())
+		if rateLimit == nil || oa.MaxRateLimitWait <= 0 || rateLimit.Reset.IsZero() ||
+			rateLimit.Reset.After(deadline) || retries == maxRateLimitRetries {
+			return resp, nil
+		}
+
+		delay := rateLimit.Reset.Sub(clock.Now())
+		if delay < minRateLimitWait {
+			delay = minRateLimitWait
+		}
+		logger.Debug("rate limited; waiting for reset", "reset", rateLimit.Reset, "delay", delay)
+		timer := clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
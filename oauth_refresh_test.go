@@ -0,0 +1,255 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func TestFlow_Refresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		stub      apiStub
+		wantToken string
+		wantErr   error
+	}{
+		{
+			name: "refreshed",
+			stub: apiStub{
+				body:        "access_token=NEW-TOKEN&refresh_token=NEW-REFRESH&expires_in=28800&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+			wantToken: "NEW-TOKEN",
+		},
+		{
+			name: "bad refresh token",
+			stub: apiStub{
+				body:        "error=invalid_grant",
+				status:      400,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+			wantErr: api.ErrInvalidGrant,
+		},
+		{
+			name: "rate limited",
+			stub: apiStub{
+				body:        `{"message":"API rate limit exceeded"}`,
+				status:      429,
+				contentType: "application/json",
+			},
+			wantErr: &api.RateLimitError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &apiClient{stubs: []apiStub{tt.stub}}
+			flow := &Flow{
+				Host:                &Host{TokenURL: "https://github.com/login/oauth/access_token"},
+				ClientID:            "CLIENT-ID",
+				ClientSecret:        "SECRET",
+				HTTPClient:          api.FromFormPoster(client),
+				TokenRequestEditors: []api.AuthRequestEditorFn{api.WithParam("resource", "RESOURCE")},
+			}
+
+			token, err := flow.Refresh("REFRESH")
+			var rateLimit *api.RateLimitError
+			switch {
+			case errors.As(tt.wantErr, &rateLimit):
+				if !errors.As(err, &rateLimit) {
+					t.Fatalf("Refresh() error = %v, want a RateLimitError", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Refresh() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Refresh() error: %v", err)
+			case token.Token != tt.wantToken || token.RefreshToken != "NEW-REFRESH" || token.ExpiresIn != 28800:
+				t.Errorf("token = %+v", token)
+			}
+
+			want := "client_id=CLIENT-ID&client_secret=SECRET&grant_type=refresh_token&refresh_token=REFRESH&resource=RESOURCE"
+			if got := client.calls[0].params.Encode(); got != want {
+				t.Errorf("params = %s", got)
+			}
+		})
+	}
+}
+
+func TestFlow_Revoke(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			{status: 200, contentType: "text/plain"},
+			{body: "error=invalid_client", status: 401, contentType: "application/x-www-form-urlencoded"},
+		},
+	}
+	flow := &Flow{
+		Host:       &Host{RevocationURL: "https://oauth.example.com/revoke"},
+		ClientID:   "CLIENT-ID",
+		HTTPClient: api.FromFormPoster(client),
+	}
+
+	if err := flow.Revoke("TOKEN"); err != nil {
+		t.Fatalf("Revoke() error: %v", err)
+	}
+	if got := client.calls[0]; got.url != "https://oauth.example.com/revoke" || got.params.Encode() != "client_id=CLIENT-ID&token=TOKEN" {
+		t.Errorf("request = %+v", got)
+	}
+	if err := flow.Revoke("TOKEN"); !errors.Is(err, api.ErrInvalidClient) {
+		t.Errorf("Revoke() error = %v, want invalid_client", err)
+	}
+
+	flow.Host = &Host{TokenURL: "https://github.com/login/oauth/access_token"}
+	if err := flow.Revoke("TOKEN"); !errors.Is(err, ErrRevocationUnsupported) {
+		t.Errorf("Revoke() error = %v, want ErrRevocationUnsupported", err)
+	}
+}
+
+func TestFlow_rateLimitWait(t *testing.T) {
+	rateLimited := apiStub{
+		body:        `{"message":"API rate limit exceeded"}`,
+		status:      429,
+		contentType: "application/json",
+		header:      http.Header{"Retry-After": {"30"}},
+	}
+	refreshed := apiStub{
+		body:        "access_token=NEW-TOKEN",
+		status:      200,
+		contentType: "application/x-www-form-urlencoded",
+	}
+	revoked := apiStub{status: 200, contentType: "text/plain"}
+
+	tests := []struct {
+		name      string
+		stubs     []apiStub
+		maxWait   time.Duration
+		revoke    bool
+		wantPosts int
+		wantWait  time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      "refresh waits for reset",
+			stubs:     []apiStub{rateLimited, refreshed},
+			maxWait:   time.Minute,
+			wantPosts: 2,
+			wantWait:  30 * time.Second,
+		},
+		{
+			name:      "revoke waits for reset",
+			stubs:     []apiStub{rateLimited, revoked},
+			maxWait:   time.Minute,
+			revoke:    true,
+			wantPosts: 2,
+			wantWait:  30 * time.Second,
+		},
+		{
+			name:      "reset beyond the maximum wait",
+			stubs:     []apiStub{rateLimited},
+			maxWait:   10 * time.Second,
+			wantPosts: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "no waiting by default",
+			stubs:     []apiStub{rateLimited},
+			wantPosts: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "gives up when rate limited repeatedly",
+			stubs:     []apiStub{rateLimited, rateLimited, rateLimited, rateLimited},
+			maxWait:   time.Hour,
+			wantPosts: 4,
+			wantWait:  90 * time.Second,
+			wantErr:   true,
+		},
+		{
+			name: "reset in the past",
+			stubs: []apiStub{
+				{
+					body:        `{"message":"API rate limit exceeded"}`,
+					status:      403,
+					contentType: "application/json",
+					header: http.Header{
+						"X-Ratelimit-Remaining": {"0"},
+						"X-Ratelimit-Reset":     {"1000"},
+					},
+				},
+				refreshed,
+			},
+			maxWait:   time.Minute,
+			wantPosts: 2,
+			wantWait:  time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			clock := oauthtest.NewClock(start)
+			clock.AutoAdvance = true
+			client := &apiClient{stubs: tt.stubs}
+			flow := &Flow{
+				Host: &Host{
+					TokenURL:      "https://github.com/login/oauth/access_token",
+					RevocationURL: "https://github.com/login/oauth/revoke",
+				},
+				ClientID:         "CLIENT-ID",
+				HTTPClient:       api.FromFormPoster(client),
+				MaxRateLimitWait: tt.maxWait,
+				Clock:            clock,
+			}
+
+			var err error
+			if tt.revoke {
+				err = flow.Revoke("TOKEN")
+			} else {
+				_, err = flow.Refresh("REFRESH")
+			}
+			var rateLimit *api.RateLimitError
+			if tt.wantErr && !errors.As(err, &rateLimit) {
+				t.Errorf("error = %v, want a RateLimitError", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("error: %v", err)
+			}
+			if client.postCount != tt.wantPosts {
+				t.Errorf("posted %d times, want %d", client.postCount, tt.wantPosts)
+			}
+			if got := clock.Now().Sub(start); got != tt.wantWait {
+				t.Errorf("waited %v, want %v", got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestFlow_rateLimitWait_canceled(t *testing.T) {
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	client := &apiClient{stubs: []apiStub{{
+		body:        `{"message":"API rate limit exceeded"}`,
+		status:      429,
+		contentType: "application/json",
+		header:      http.Header{"Retry-After": {"30"}},
+	}}}
+	flow := &Flow{
+		Host:             &Host{TokenURL: "https://github.com/login/oauth/access_token"},
+		ClientID:         "CLIENT-ID",
+		HTTPClient:       api.FromFormPoster(client),
+		MaxRateLimitWait: time.Minute,
+		Clock:            clock,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := flow.RefreshContext(ctx, "REFRESH"); !errors.Is(err, context.Canceled) {
+		t.Errorf("RefreshContext() error = %v, want context.Canceled", err)
+	}
+	if client.postCount != 1 {
+		t.Errorf("posted %d times, want 1", client.postCount)
+	}
+}
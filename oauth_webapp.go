@@ -3,7 +3,8 @@ package oauth
 import (
 	"context"
 	"fmt"
-	"net/http"
+	"io"
+	"os"
 
 	"github.com/cli/browser"
 	"github.com/cli/oauth/api"
@@ -13,20 +14,25 @@ import (
 // WebAppFlow starts a local HTTP server, opens the web browser to initiate the OAuth Web application
 // flow, blocks until the user completes authorization and is redirected back, and returns the access token.
 func (oa *Flow) WebAppFlow() (*api.AccessToken, error) {
-	host := oa.Host
+	return oa.WebAppFlowContext(context.Background())
+}
 
-	if host == nil {
-		parsedHost, err := NewGitHubHost("https://" + oa.Hostname)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing the hostname '%s': %w", oa.Hostname, err)
-		}
-		host = parsedHost
+// WebAppFlowContext is like WebAppFlow, but aborts the flow and any request in progress when ctx is done.
+func (oa *Flow) WebAppFlowContext(ctx context.Context) (*api.AccessToken, error) {
+	host, err := oa.resolveHost()
+	if err != nil {
+		return nil, err
 	}
 
 	flow, err := webapp.InitFlow()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		_ = flow.Close()
+	}()
+	flow.Logger = oa.Logger
+	flow.Pages = oa.Pages
 
 	params := webapp.BrowserParams{
 		ClientID:    oa.ClientID,
@@ -34,8 +40,9 @@ func (oa *Flow) WebAppFlow() (*api.AccessToken, error) {
 		Scopes:      oa.Scopes,
 		Audience:    oa.Audience,
 		AllowSignup: true,
+		OIDC:        oa.OIDC,
 	}
-	browserURL, err := flow.BrowserURL(host.AuthorizeURL, params)
+	browserURL, err := flow.BrowserURL(host.AuthorizeURL, params, oa.AuthRequestEditors...)
 	if err != nil {
 		return nil, err
 	}
@@ -49,17 +56,37 @@ func (oa *Flow) WebAppFlow() (*api.AccessToken, error) {
 		browseURL = browser.OpenURL
 	}
 
-	err = browseURL(browserURL)
-	if err != nil {
-		return nil, fmt.Errorf("error opening the web browser: %w", err)
+	waitOpts := webapp.WaitOptions{
+		ClientSecret:   oa.ClientSecret,
+		RequestOptions: oa.RequestOptions,
+		RequestEditors: oa.TokenRequestEditors,
+	}
+	api.RedactLogger(oa.Logger).Debug("opening web browser", "url", browserURL)
+	browseErr := browseURL(browserURL)
+	if browseErr != nil && !oa.ManualWebAppInput {
+		return nil, fmt.Errorf("error opening the web browser: %w", browseErr)
 	}
 
-	httpClient := oa.HTTPClient
-	if httpClient == nil {
-		httpClient = http.DefaultClient
+	if oa.ManualWebAppInput {
+		stdin := oa.Stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		stdout := oa.Stdout
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+		if browseErr != nil {
+			api.RedactLogger(oa.Logger).Debug("could not open web browser", "error", browseErr)
+			fmt.Fprintf(stdout, "Open this URL in a web browser to authorize the app:\n\n  %s\n\n", browserURL)
+		} else {
+			fmt.Fprintf(stdout, "If the web browser did not open, open this URL to authorize the app:\n\n  %s\n\n", browserURL)
+		}
+		fmt.Fprintf(stdout, "If the browser runs on another machine and can't reach the app, paste the address of the page it was redirected to here: ")
+		// Stdin is not closed when the flow completes, since it is not dedicated to the flow.
+		waitOpts.ManualInput = struct{ io.Reader }{stdin}
+		waitOpts.ManualOutput = stdout
 	}
 
-	return flow.Wait(context.TODO(), httpClient, host.TokenURL, webapp.WaitOptions{
-		ClientSecret: oa.ClientSecret,
-	})
+	return flow.Wait(ctx, oa.httpClient(), host.TokenURL, waitOpts)
 }
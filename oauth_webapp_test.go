@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth/api"
+)
+
+func TestFlow_WebAppFlow_manualInput(t *testing.T) {
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			},
+		},
+	}
+
+	stdin, pasted := io.Pipe()
+	stdout := &bytes.Buffer{}
+	flow := &Flow{
+		Host: &Host{
+			AuthorizeURL: "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+		},
+		ClientID:          "CLIENT-ID",
+		CallbackURI:       "http://127.0.0.1/callback",
+		ManualWebAppInput: true,
+		HTTPClient:        api.FromFormPoster(client),
+		Stdin:             stdin,
+		Stdout:            stdout,
+		BrowseURL: func(u string) error {
+			authorizeURL, err := url.Parse(u)
+			if err != nil {
+				return err
+			}
+			q := authorizeURL.Query()
+			go func() {
+				_, _ = io.WriteString(pasted, q.Get("redirect_uri")+"?code=ABC-123&state="+url.QueryEscape(q.Get("state"))+"\n")
+			}()
+			// Browsers are not available over SSH.
+			return errors.New("no browser")
+		},
+	}
+
+	token, err := flow.WebAppFlow()
+	if err != nil {
+		t.Fatalf("WebAppFlow() error: %v", err)
+	}
+	if token.Token != "ATOKEN" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if got := client.calls[0].params.Get("code"); got != "ABC-123" {
+		t.Errorf("exchanged code %q", got)
+	}
+	if !strings.Contains(stdout.String(), "https://github.com/login/oauth/authorize?") {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+}
+
+func TestFlow_WebAppFlow_oidcParams(t *testing.T) {
+	var browsed string
+	flow := &Flow{
+		Host: &Host{
+			AuthorizeURL: "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+		},
+		ClientID:    "CLIENT-ID",
+		CallbackURI: "http://127.0.0.1/callback",
+		OIDC:        api.OIDCParams{LoginHint: "monalisa", Nonce: "NONCE"},
+		BrowseURL: func(u string) error {
+			browsed = u
+			return errors.New("no browser")
+		},
+	}
+
+	if _, err := flow.WebAppFlow(); err == nil {
+		t.Fatal("expected an error")
+	}
+	u, err := url.Parse(browsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("login_hint") != "monalisa" || q.Get("nonce") != "NONCE" {
+		t.Errorf("browser URL = %s", browsed)
+	}
+}
@@ -0,0 +1,244 @@
+package oauthtest
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cli/oauth/api"
+)
+
+// BrowserAction is what the user of a Browser does once the authorization server redirects back to
+// the app.
+type BrowserAction int
+
+const (
+	// Approve follows the redirect back to the app unchanged.
+	Approve BrowserAction = iota
+	// Deny replaces the authorization code in the redirect with an "access_denied" error, as if the
+	// user had declined to authorize the app.
+	Deny
+	// TamperState changes the state parameter of the redirect, as a forged redirect would.
+	TamperState
+	// DuplicateCallback follows the redirect back to the app twice, as if the page was reloaded.
+	DuplicateCallback
+)
+
+// maxPageSize is the number of bytes of each page that a Browser keeps.
+const maxPageSize = 1 << 20
+
+// Page is a page that a Browser landed on.
+type Page struct {
+	// URL is the address of the page.
+	URL string
+	// StatusCode is the HTTP status of the page.
+	StatusCode int
+	// Body is the content of the page.
+	Body string
+	// Err is the error that kept the page from loading, if any.
+	Err error
+}
+
+// Browser is a headless web browser for testing the web application flow without user interaction.
+// Its BrowseURL method can be used as oauth.Flow.BrowseURL: it fetches the authorize URL, follows
+// the redirects of the authorization server, e.g. a Server, and lands on the local server of the
+// flow. It is safe for concurrent use.
+type Browser struct {
+	// Action is what the user does once redirected back to the app. Defaults to Approve.
+	Action BrowserAction
+	// Client makes the requests of the browser. It must not follow redirects itself. Optional:
+	// defaults to a client that uses http.DefaultTransport.
+	Client *http.Client
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	pages []Page
+}
+
+// BrowseURL starts navigating to u in the background and returns immediately, like a browser that
+// was launched. Use Wait, after BrowseURL was called, to get the pages that the browser landed on.
+func (b *Browser) BrowseURL(u string) error {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.navigate(u)
+	}()
+	return nil
+}
+
+// Wait blocks until all navigations started by BrowseURL have completed and returns the final page
+// of each navigation in the order they completed, along with the repeated callback for
+// DuplicateCallback.
+func (b *Browser) Wait() []Page {
+	b.wg.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Page(nil), b.pages...)
+}
+
+// maxRedirects is the number of redirects that a Browser follows before it gives up, like browsers do.
+const maxRedirects = 10
+
+func (b *Browser) navigate(u string) {
+	client := b.Client
+	if client == nil {
+		client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	// The redirect back to the app is recognized by the redirect_uri of the authorization request.
+	var redirectURI *url.URL
+	if start, err := url.Parse(u); err == nil {
+		redirectURI, _ = url.Parse(start.Query().Get("redirect_uri"))
+	}
+
+	method, form := http.MethodGet, url.Values(nil)
+	for i := 0; ; i++ {
+		if i > maxRedirects {
+			b.land(Page{URL: u, Err: errors.New("too many redirects")})
+			return
+		}
+
+		target, err := url.Parse(u)
+		if err != nil {
+			b.land(Page{URL: u, Err: err})
+			return
+		}
+		if i > 0 && isRedirectBack(target, redirectURI) {
+			b.callback(client, method, target, form)
+			return
+		}
+
+		page, resp := b.fetch(client, method, u, form)
+		if page.Err != nil {
+			b.land(page)
+			return
+		}
+		if location := resp.Header.Get("Location"); location != "" && resp.StatusCode/100 == 3 {
+			next, err := target.Parse(location)
+			if err != nil {
+				b.land(Page{URL: u, Err: err})
+				return
+			}
+			u, method, form = next.String(), http.MethodGet, nil
+			continue
+		}
+		if action, fields, ok := parseFormPost(page.Body); ok {
+			u, method, form = action, http.MethodPost, fields
+			continue
+		}
+		b.land(page)
+		return
+	}
+}
+
+// callback applies the Action of the browser to the redirect back to the app and follows it.
+func (b *Browser) callback(client *http.Client, method string, target *url.URL, form url.Values) {
+	params := form
+	if method == http.MethodGet {
+		params = target.Query()
+	}
+	params = cloneValues(params)
+
+	switch b.Action {
+	case Deny:
+		params.Del("code")
+		params.Set("error", string(api.ErrAccessDenied))
+		params.Set("error_description", "The user has denied your application access.")
+	case TamperState:
+		params.Set("state", "tampered-"+params.Get("state"))
+	}
+
+	if method == http.MethodGet {
+		target.RawQuery = params.Encode()
+		params = nil
+	}
+
+	page, _ := b.fetch(client, method, target.String(), params)
+	b.land(page)
+	if b.Action == DuplicateCallback {
+		page, _ := b.fetch(client, method, target.String(), params)
+		b.land(page)
+	}
+}
+
+// fetch requests u and reads the resulting page. The response is returned for its headers.
+func (b *Browser) fetch(client *http.Client, method, u string, form url.Values) (Page, *http.Response) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return Page{URL: u, Err: err}, nil
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Page{URL: u, Err: err}, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return Page{URL: u, StatusCode: resp.StatusCode, Err: fmt.Errorf("reading page: %w", err)}, resp
+	}
+	return Page{URL: u, StatusCode: resp.StatusCode, Body: string(content)}, resp
+}
+
+func (b *Browser) land(page Page) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pages = append(b.pages, page)
+}
+
+var (
+	formPostAction = regexp.MustCompile(`<form method="post" action="([^"]*)">`)
+	formPostInput  = regexp.MustCompile(`<input type="hidden" name="([^"]*)" value="([^"]*)">`)
+)
+
+// parseFormPost returns the target and the fields of a page that submits a form on load, such as the
+// form_post response of a Server.
+func parseFormPost(page string) (string, url.Values, bool) {
+	if !strings.Contains(page, "document.forms[0].submit()") {
+		return "", nil, false
+	}
+	m := formPostAction.FindStringSubmatch(page)
+	if m == nil {
+		return "", nil, false
+	}
+	fields := url.Values{}
+	for _, input := range formPostInput.FindAllStringSubmatch(page, -1) {
+		fields.Add(html.UnescapeString(input[1]), html.UnescapeString(input[2]))
+	}
+	return html.UnescapeString(m[1]), fields, true
+}
+
+// isRedirectBack reports whether target is the redirect_uri of the authorization request.
+func isRedirectBack(target, redirectURI *url.URL) bool {
+	return redirectURI != nil && redirectURI.Host != "" && target.Scheme == redirectURI.Scheme &&
+		target.Host == redirectURI.Host && target.Path == redirectURI.Path
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for k, vs := range values {
+		clone[k] = append([]string(nil), vs...)
+	}
+	return clone
+}
@@ -0,0 +1,135 @@
+package oauthtest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func TestBrowser_WebAppFlow(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    oauthtest.BrowserAction
+		formPost  bool
+		wantErr   error
+		wantPages int
+	}{
+		{
+			name:      "approve",
+			action:    oauthtest.Approve,
+			wantPages: 1,
+		},
+		{
+			name:      "approve with form_post",
+			action:    oauthtest.Approve,
+			formPost:  true,
+			wantPages: 1,
+		},
+		{
+			name:      "deny",
+			action:    oauthtest.Deny,
+			wantErr:   api.ErrAccessDenied,
+			wantPages: 1,
+		},
+		{
+			name:      "duplicate callback",
+			action:    oauthtest.DuplicateCallback,
+			wantPages: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oauthtest.NewServer(oauthtest.Config{})
+			defer s.Close()
+
+			browser := &oauthtest.Browser{Action: tt.action}
+			flow := newFlow(t, s)
+			flow.BrowseURL = browser.BrowseURL
+			if tt.formPost {
+				flow.AuthRequestEditors = append(flow.AuthRequestEditors, api.WithParam("response_mode", "form_post"))
+			}
+
+			token, err := flow.WebAppFlow()
+			pages := browser.Wait()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("WebAppFlow() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("WebAppFlow() error: %v", err)
+			} else if !s.Valid(token.Token) {
+				t.Errorf("token %q was not issued by the server", token.Token)
+			}
+
+			if len(pages) != tt.wantPages {
+				t.Fatalf("landed on %d pages, want %d: %+v", len(pages), tt.wantPages, pages)
+			}
+			if pages[0].Err != nil || !strings.HasPrefix(pages[0].URL, "http://127.0.0.1:") {
+				t.Errorf("pages[0] = %+v, want the local callback", pages[0])
+			}
+			if tt.action == oauthtest.DuplicateCallback && pages[1].Err == nil && pages[1].StatusCode == http.StatusOK {
+				t.Errorf("duplicate callback was accepted: %+v", pages[1])
+			}
+
+			exchanges := 0
+			for _, r := range s.Requests() {
+				if r.Path == oauthtest.TokenPath {
+					exchanges++
+				}
+			}
+			want := 1
+			if tt.wantErr != nil {
+				want = 0
+			}
+			if exchanges != want {
+				t.Errorf("exchanged %d codes, want %d", exchanges, want)
+			}
+		})
+	}
+}
+
+func TestBrowser_tamperState(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{})
+	defer s.Close()
+
+	browser := &oauthtest.Browser{Action: oauthtest.TamperState}
+	flow := newFlow(t, s)
+	browsed := make(chan struct{})
+	flow.BrowseURL = func(u string) error {
+		defer close(browsed)
+		return browser.BrowseURL(u)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := flow.WebAppFlowContext(ctx)
+		errs <- err
+	}()
+
+	// The flow keeps waiting for a genuine redirect, so wait for the browser instead.
+	<-browsed
+	pages := browser.Wait()
+	if len(pages) != 1 {
+		t.Fatalf("landed on %d pages, want 1", len(pages))
+	}
+	if pages[0].StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", pages[0].StatusCode)
+	}
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("WebAppFlowContext() error = %v, want context.Canceled", err)
+	}
+	for _, r := range s.Requests() {
+		if r.Path == oauthtest.TokenPath {
+			t.Error("tampered redirect led to a code exchange")
+		}
+	}
+}
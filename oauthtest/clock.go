@@ -0,0 +1,99 @@
+// Package oauthtest provides helpers for testing applications that perform OAuth authorization using
+// this module, without waiting on real time or talking to a real server.
+package oauthtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cli/oauth/device"
+)
+
+// Clock is a fake clock that satisfies device.Clock. Its time only moves forward when Advance is
+// called or, with AutoAdvance enabled, as soon as something starts waiting on it.
+type Clock struct {
+	// AutoAdvance makes every call to NewTimer immediately move the clock forward by the requested
+	// duration, so that code polling on this clock never blocks. It must be set before the clock is used.
+	AutoAdvance bool
+
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	clock *Clock
+	at    time.Time
+	ch    chan time.Time
+}
+
+// C returns the channel that receives the fake time when the timer fires.
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop removes the timer from the clock unless it has already fired.
+func (t *timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NewClock returns a fake clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer that receives the fake time once the clock has advanced by d.
+func (c *Clock) NewTimer(d time.Duration) device.Timer {
+	c.mu.Lock()
+	t := &timer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+
+	if c.AutoAdvance {
+		c.Advance(d)
+	} else if d <= 0 {
+		c.Advance(0)
+	}
+	return t
+}
+
+// Advance moves the clock forward by d and fires all timers that have become due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// Pending returns the number of timers that are waiting for the clock to advance and have not been
+// stopped.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
@@ -0,0 +1,71 @@
+package oauthtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock_Advance(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+
+	ch := c.NewTimer(5 * time.Second).C()
+	if c.Pending() != 1 {
+		t.Fatalf("Pending() = %d, want 1", c.Pending())
+	}
+
+	c.Advance(4 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("timer fired too early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case got := <-ch:
+		if want := start.Add(5 * time.Second); !got.Equal(want) {
+			t.Errorf("timer fired at %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+	if c.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", c.Pending())
+	}
+}
+
+func TestClock_AutoAdvance(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+	c.AutoAdvance = true
+
+	<-c.NewTimer(time.Minute).C()
+	<-c.NewTimer(30 * time.Second).C()
+
+	if got, want := c.Now(), start.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+}
+
+func TestClock_Stop(t *testing.T) {
+	c := NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	timer := c.NewTimer(5 * time.Second)
+	if !timer.Stop() {
+		t.Error("Stop() = false, want true")
+	}
+	if c.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", c.Pending())
+	}
+
+	c.Advance(5 * time.Second)
+	select {
+	case <-timer.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true for a stopped timer")
+	}
+}
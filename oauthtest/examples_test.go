@@ -0,0 +1,69 @@
+package oauthtest_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/oauthtest"
+)
+
+// Run Device flow end to end against a fake server that keeps the user pending for a few polls,
+// using a fake clock so that polling does not wait on real time.
+func ExampleServer() {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID", PendingPolls: 3})
+	defer s.Close()
+
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		panic(err)
+	}
+	clock := oauthtest.NewClock(time.Now())
+	clock.AutoAdvance = true
+	flow := &oauth.Flow{
+		Host:        host,
+		ClientID:    "CLIENT-ID",
+		Scopes:      []string{"repo"},
+		Clock:       clock,
+		DisplayCode: func(code, uri string) error { return nil },
+		BrowseURL:   func(uri string) error { return nil },
+	}
+
+	accessToken, err := flow.DeviceFlow()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(s.Valid(accessToken.Token), accessToken.Scope)
+	// Output: true repo
+}
+
+// Run the web application flow end to end, with a headless browser that approves the app on the fake
+// server and follows the redirect to the local server of the flow.
+func ExampleBrowser() {
+	s := oauthtest.NewServer(oauthtest.Config{ClientID: "CLIENT-ID", ClientSecret: "SECRET"})
+	defer s.Close()
+
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		panic(err)
+	}
+	browser := &oauthtest.Browser{Action: oauthtest.Approve}
+	flow := &oauth.Flow{
+		Host:         host,
+		ClientID:     "CLIENT-ID",
+		ClientSecret: "SECRET",
+		CallbackURI:  "http://127.0.0.1/callback",
+		Scopes:       []string{"repo"},
+		BrowseURL:    browser.BrowseURL,
+	}
+
+	accessToken, err := flow.WebAppFlow()
+	if err != nil {
+		panic(err)
+	}
+
+	pages := browser.Wait()
+	fmt.Println(s.Valid(accessToken.Token), pages[0].StatusCode)
+	// Output: true 200
+}
@@ -0,0 +1,479 @@
+package oauthtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cli/oauth/api"
+)
+
+// The endpoint paths of a Server, which match those of GitHub so that oauth.NewGitHubHost can be
+// used with the server URL.
+const (
+	DeviceCodePath = "/login/device/code"
+	AuthorizePath  = "/login/oauth/authorize"
+	TokenPath      = "/login/oauth/access_token"
+	RevocationPath = "/login/oauth/revoke"
+	// VerificationPath is where device codes direct the user to enter their one-time code.
+	VerificationPath = "/login/device"
+)
+
+// Config scripts the behavior of a Server.
+type Config struct {
+	// ClientID is the only client ID that the server accepts. Optional: by default, any client ID is accepted.
+	ClientID string
+	// ClientSecret is the client secret required to exchange an authorization code or a refresh token.
+	// Optional: by default, no secret is required.
+	ClientSecret string
+	// JSON makes the server respond with JSON documents. By default, like GitHub, the server responds
+	// with JSON only if the request accepts "application/json", and form-encoded otherwise.
+	JSON bool
+	// ErrorStatus is the HTTP status of error responses from the token endpoint. Defaults to 200,
+	// like GitHub; OAuth servers that follow RFC 6749 use 400.
+	ErrorStatus int
+
+	// DeviceFlowUnsupported makes the device code endpoint respond with 404, like servers that do not
+	// implement Device flow.
+	DeviceFlowUnsupported bool
+	// Interval is the polling interval in seconds handed out with device codes. Defaults to 0, so
+	// that polling does not slow down tests.
+	Interval int
+	// ExpiresIn is the lifetime in seconds handed out with device codes. Defaults to 900.
+	ExpiresIn int
+	// SlowDownPolls is the number of polls for each device code that are answered with "slow_down",
+	// each asking for an interval that is 5 seconds longer. Pair it with a fake Clock.
+	SlowDownPolls int
+	// PendingPolls is the number of further polls for each device code that are answered with
+	// "authorization_pending" before the user completes authorization.
+	PendingPolls int
+	// Expire makes the device code expire once the user would have completed authorization, so that
+	// polling fails with "expired_token".
+	Expire bool
+	// Deny makes the user deny authorization: polls fail with "access_denied" and the authorize
+	// endpoint redirects back with that error.
+	Deny bool
+
+	// TokenError, if set, is the error that every token request fails with, e.g. api.ErrIncorrectClientCredentials.
+	TokenError api.ErrorCode
+	// TokenExpiresIn is the lifetime in seconds of issued access tokens. If positive, a refresh token is
+	// issued along with every access token. Defaults to 0, i.e. tokens do not expire.
+	TokenExpiresIn int
+	// Scopes are the scopes granted to every token. Defaults to the scopes that were requested.
+	Scopes []string
+}
+
+// Request is a request received by a Server.
+type Request struct {
+	Method string
+	// Path is the URL path, e.g. TokenPath.
+	Path string
+	// Form holds the query and the form-encoded body parameters.
+	Form url.Values
+}
+
+// Server is a fake OAuth authorization server for tests. It implements Device flow, the web
+// application flow, refreshing tokens and revoking them, with the endpoint paths of GitHub. It is
+// safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the server, of the form http://ipaddr:port with no trailing slash.
+	URL string
+
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	cfg           Config
+	devices       map[string]*deviceGrant
+	codes         map[string]grant
+	accessTokens  map[string]bool
+	refreshTokens map[string]grant
+	requests      []Request
+}
+
+// grant is what the user has authorized a client to do.
+type grant struct {
+	clientID string
+	scope    string
+}
+
+type deviceGrant struct {
+	grant
+	polls int
+}
+
+// NewServer starts a Server with the behavior scripted by cfg. The caller should call Close when done.
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		cfg:           cfg,
+		devices:       make(map[string]*deviceGrant),
+		codes:         make(map[string]grant),
+		accessTokens:  make(map[string]bool),
+		refreshTokens: make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(DeviceCodePath, s.handleDeviceCode)
+	mux.HandleFunc(AuthorizePath, s.handleAuthorize)
+	mux.HandleFunc(TokenPath, s.handleToken)
+	mux.HandleFunc(RevocationPath, s.handleRevoke)
+	mux.HandleFunc(VerificationPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<p>Enter the code displayed on your device.</p>\n"))
+	})
+	s.srv = httptest.NewServer(s.record(mux))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Update changes the behavior of the server, e.g. between two flows. Device codes and authorization
+// codes that were already handed out are subject to the new behavior.
+func (s *Server) Update(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.cfg)
+}
+
+// DeviceCodeURL returns the URL of the device code endpoint.
+func (s *Server) DeviceCodeURL() string {
+	return s.URL + DeviceCodePath
+}
+
+// AuthorizeURL returns the URL of the authorize endpoint.
+func (s *Server) AuthorizeURL() string {
+	return s.URL + AuthorizePath
+}
+
+// TokenURL returns the URL of the token endpoint.
+func (s *Server) TokenURL() string {
+	return s.URL + TokenPath
+}
+
+// RevocationURL returns the URL of the token revocation endpoint, as described in RFC 7009.
+func (s *Server) RevocationURL() string {
+	return s.URL + RevocationPath
+}
+
+// Requests returns all requests that the server has received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Valid reports whether token is an access token that the server issued and has not revoked.
+func (s *Server) Valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accessTokens[token]
+}
+
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Form: r.Form})
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.DeviceFlowUnsupported {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.validClient(r) {
+		s.writeError(w, r, http.StatusBadRequest, api.ErrInvalidClient)
+		return
+	}
+
+	deviceCode := newSecret("dc_")
+	s.devices[deviceCode] = &deviceGrant{grant: s.newGrant(r)}
+
+	expiresIn := s.cfg.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = 900
+	}
+	s.write(w, r, http.StatusOK, url.Values{
+		"device_code":      {deviceCode},
+		"user_code":        {userCode()},
+		"verification_uri": {s.URL + VerificationPath},
+		"expires_in":       {strconv.Itoa(expiresIn)},
+		"interval":         {strconv.Itoa(s.cfg.Interval)},
+	})
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme != "http" || !isLoopback(redirectURI.Hostname()) {
+		http.Error(w, "redirect_uri must be a loopback http URL", http.StatusBadRequest)
+		return
+	}
+	if !s.validClient(r) {
+		http.Error(w, "unknown client_id", http.StatusNotFound)
+		return
+	}
+
+	params := url.Values{}
+	if s.cfg.Deny {
+		params.Set("error", string(api.ErrAccessDenied))
+		params.Set("error_description", "The user has denied your application access.")
+	} else {
+		code := newSecret("ac_")
+		s.codes[code] = s.newGrant(r)
+		params.Set("code", code)
+	}
+	if state := r.Form.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+
+	if r.Form.Get("response_mode") == "form_post" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = formPostTemplate.Execute(w, struct {
+			Action string
+			Params url.Values
+		}{redirectURI.String(), params})
+		return
+	}
+
+	query := redirectURI.Query()
+	for k, vs := range params {
+		query[k] = vs
+	}
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html><body onload="document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{- range $k, $vs := .Params}}{{range $vs}}
+<input type="hidden" name="{{$k}}" value="{{.}}">
+{{- end}}{{end}}
+</form>
+</body></html>
+`))
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errorStatus := s.cfg.ErrorStatus
+	if errorStatus == 0 {
+		errorStatus = http.StatusOK
+	}
+	if s.cfg.TokenError != "" {
+		s.writeError(w, r, errorStatus, s.cfg.TokenError)
+		return
+	}
+	if !s.validClient(r) {
+		s.writeError(w, r, errorStatus, api.ErrInvalidClient)
+		return
+	}
+
+	switch grantType := r.Form.Get("grant_type"); grantType {
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		s.pollDeviceCode(w, r, errorStatus)
+	case "", "authorization_code":
+		if !s.validSecret(r) {
+			s.writeError(w, r, errorStatus, api.ErrIncorrectClientCredentials)
+			return
+		}
+		code := r.Form.Get("code")
+		g, ok := s.codes[code]
+		if !ok || g.clientID != r.Form.Get("client_id") {
+			s.writeError(w, r, errorStatus, api.ErrBadVerificationCode)
+			return
+		}
+		delete(s.codes, code)
+		s.writeToken(w, r, g)
+	case "refresh_token":
+		if !s.validSecret(r) {
+			s.writeError(w, r, errorStatus, api.ErrIncorrectClientCredentials)
+			return
+		}
+		refreshToken := r.Form.Get("refresh_token")
+		g, ok := s.refreshTokens[refreshToken]
+		if !ok || g.clientID != r.Form.Get("client_id") {
+			s.writeError(w, r, errorStatus, api.ErrInvalidGrant)
+			return
+		}
+		delete(s.refreshTokens, refreshToken)
+		s.writeToken(w, r, g)
+	default:
+		s.writeError(w, r, errorStatus, api.ErrUnsupportedGrantType)
+	}
+}
+
+func (s *Server) pollDeviceCode(w http.ResponseWriter, r *http.Request, errorStatus int) {
+	deviceCode := r.Form.Get("device_code")
+	d, ok := s.devices[deviceCode]
+	if !ok || d.clientID != r.Form.Get("client_id") {
+		s.writeError(w, r, errorStatus, api.ErrIncorrectDeviceCode)
+		return
+	}
+
+	d.polls++
+	switch {
+	case d.polls <= s.cfg.SlowDownPolls:
+		s.write(w, r, errorStatus, url.Values{
+			"error":    {string(api.ErrSlowDown)},
+			"interval": {strconv.Itoa(s.cfg.Interval + 5*(d.polls))},
+		})
+	case d.polls <= s.cfg.SlowDownPolls+s.cfg.PendingPolls:
+		s.writeError(w, r, errorStatus, api.ErrAuthorizationPending)
+	case s.cfg.Expire:
+		s.writeError(w, r, errorStatus, api.ErrExpiredToken)
+	case s.cfg.Deny:
+		s.writeError(w, r, errorStatus, api.ErrAccessDenied)
+	default:
+		delete(s.devices, deviceCode)
+		s.writeToken(w, r, d.grant)
+	}
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.validClient(r) {
+		s.writeError(w, r, http.StatusUnauthorized, api.ErrInvalidClient)
+		return
+	}
+	// As per RFC 7009, revoking an unknown token is not an error.
+	token := r.Form.Get("token")
+	delete(s.accessTokens, token)
+	delete(s.refreshTokens, token)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) validClient(r *http.Request) bool {
+	return s.cfg.ClientID == "" || r.Form.Get("client_id") == s.cfg.ClientID
+}
+
+func (s *Server) validSecret(r *http.Request) bool {
+	return s.cfg.ClientSecret == "" || r.Form.Get("client_secret") == s.cfg.ClientSecret
+}
+
+func (s *Server) newGrant(r *http.Request) grant {
+	scope := r.Form.Get("scope")
+	if s.cfg.Scopes != nil {
+		scope = strings.Join(s.cfg.Scopes, " ")
+	}
+	return grant{clientID: r.Form.Get("client_id"), scope: scope}
+}
+
+func (s *Server) writeToken(w http.ResponseWriter, r *http.Request, g grant) {
+	accessToken := newSecret("gho_")
+	s.accessTokens[accessToken] = true
+
+	values := url.Values{
+		"access_token": {accessToken},
+		"token_type":   {"bearer"},
+		"scope":        {g.scope},
+	}
+	if s.cfg.TokenExpiresIn > 0 {
+		refreshToken := newSecret("ghr_")
+		s.refreshTokens[refreshToken] = g
+		values.Set("expires_in", strconv.Itoa(s.cfg.TokenExpiresIn))
+		values.Set("refresh_token", refreshToken)
+	}
+	s.write(w, r, http.StatusOK, values)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, code api.ErrorCode) {
+	s.write(w, r, status, url.Values{
+		"error":             {string(code)},
+		"error_description": {fmt.Sprintf("The server responded with %s.", code)},
+	})
+}
+
+// write responds with values encoded as JSON or as a form, depending on the configuration and on
+// what the request accepts.
+func (s *Server) write(w http.ResponseWriter, r *http.Request, status int, values url.Values) {
+	if !s.cfg.JSON && !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(values.Encode()))
+		return
+	}
+
+	fields := make(map[string]any, len(values))
+	for k := range values {
+		v := values.Get(k)
+		if n, err := strconv.Atoi(v); err == nil && (k == "expires_in" || k == "interval") {
+			fields[k] = n
+		} else {
+			fields[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(fields)
+}
+
+// newSecret returns a random value with prefix, like the tokens and codes issued by GitHub.
+func newSecret(prefix string) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// userCode returns a one-time code of the form "ABCD-1234".
+func userCode() string {
+	code := strings.ToUpper(newSecret("")[:8])
+	return code[:4] + "-" + code[4:]
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,215 @@
+package oauthtest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth"
+	"github.com/cli/oauth/api"
+	"github.com/cli/oauth/device"
+	"github.com/cli/oauth/oauthtest"
+)
+
+func newFlow(t *testing.T, s *oauthtest.Server) *oauth.Flow {
+	t.Helper()
+	host, err := oauth.NewGitHubHost(s.URL)
+	if err != nil {
+		t.Fatalf("NewGitHubHost() error: %v", err)
+	}
+	clock := oauthtest.NewClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	clock.AutoAdvance = true
+	return &oauth.Flow{
+		Host:        host,
+		ClientID:    "CLIENT-ID",
+		Scopes:      []string{"repo", "read:org"},
+		CallbackURI: "http://127.0.0.1/callback",
+		Clock:       clock,
+		DisplayCode: func(string, string) error { return nil },
+		BrowseURL:   func(string) error { return nil },
+		Stdout:      io.Discard,
+	}
+}
+
+func TestServer_DeviceFlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     oauthtest.Config
+		wantErr error
+	}{
+		{
+			name: "granted after slowing down",
+			cfg:  oauthtest.Config{SlowDownPolls: 1, PendingPolls: 3},
+		},
+		{
+			name: "JSON responses",
+			cfg:  oauthtest.Config{JSON: true, PendingPolls: 1},
+		},
+		{
+			name:    "denied",
+			cfg:     oauthtest.Config{PendingPolls: 1, Deny: true},
+			wantErr: api.ErrAccessDenied,
+		},
+		{
+			name:    "denied with RFC 6749 status",
+			cfg:     oauthtest.Config{JSON: true, Deny: true, ErrorStatus: http.StatusBadRequest},
+			wantErr: api.ErrAccessDenied,
+		},
+		{
+			name:    "expired",
+			cfg:     oauthtest.Config{PendingPolls: 2, Expire: true},
+			wantErr: device.ErrTimeout,
+		},
+		{
+			name:    "token error",
+			cfg:     oauthtest.Config{TokenError: api.ErrDeviceFlowDisabled},
+			wantErr: api.ErrDeviceFlowDisabled,
+		},
+		{
+			name:    "unknown client",
+			cfg:     oauthtest.Config{ClientID: "OTHER-ID"},
+			wantErr: api.ErrInvalidClient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oauthtest.NewServer(tt.cfg)
+			defer s.Close()
+
+			token, err := newFlow(t, s).DeviceFlow()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DeviceFlow() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DeviceFlow() error: %v", err)
+			}
+			if !s.Valid(token.Token) {
+				t.Errorf("token %q was not issued by the server", token.Token)
+			}
+			if token.Scope != "repo read:org" {
+				t.Errorf("Scope = %q", token.Scope)
+			}
+
+			polls := 0
+			for _, r := range s.Requests() {
+				if r.Path == oauthtest.TokenPath {
+					polls++
+				}
+			}
+			if want := tt.cfg.SlowDownPolls + tt.cfg.PendingPolls + 1; polls != want {
+				t.Errorf("polled %d times, want %d", polls, want)
+			}
+		})
+	}
+}
+
+func TestServer_DetectFlow_fallsBackToWebApp(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{
+		ClientSecret:          "SECRET",
+		DeviceFlowUnsupported: true,
+	})
+	defer s.Close()
+
+	flow := newFlow(t, s)
+	flow.ClientSecret = "SECRET"
+	browser := &oauthtest.Browser{}
+	flow.BrowseURL = browser.BrowseURL
+
+	token, err := flow.DetectFlow()
+	if err != nil {
+		t.Fatalf("DetectFlow() error: %v", err)
+	}
+	if !s.Valid(token.Token) {
+		t.Errorf("token %q was not issued by the server", token.Token)
+	}
+	if pages := browser.Wait(); len(pages) != 1 || pages[0].StatusCode != http.StatusOK {
+		t.Errorf("pages = %+v", pages)
+	}
+}
+
+func TestServer_refreshAndRevoke(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{TokenExpiresIn: 28800})
+	defer s.Close()
+
+	token, err := newFlow(t, s).DeviceFlow()
+	if err != nil {
+		t.Fatalf("DeviceFlow() error: %v", err)
+	}
+	if token.RefreshToken == "" || token.ExpiresIn != 28800 {
+		t.Fatalf("token = %+v, want an expiring token", token)
+	}
+
+	refresh := func(refreshToken string) (*api.AccessToken, error) {
+		resp, err := api.PostForm(context.Background(), http.DefaultClient, s.TokenURL(), url.Values{
+			"client_id":     {"CLIENT-ID"},
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		}, api.RequestOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return resp.AccessToken()
+	}
+
+	refreshed, err := refresh(token.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+	if refreshed.Token == token.Token || refreshed.RefreshToken == token.RefreshToken {
+		t.Errorf("refresh did not issue new tokens")
+	}
+	if _, err := refresh(token.RefreshToken); !errors.Is(err, api.ErrInvalidGrant) {
+		t.Errorf("reusing a refresh token: error = %v, want invalid_grant", err)
+	}
+
+	resp, err := http.PostForm(s.RevocationURL(), url.Values{
+		"client_id": {"CLIENT-ID"},
+		"token":     {refreshed.Token},
+	})
+	if err != nil {
+		t.Fatalf("revoke error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("revoke status = %d", resp.StatusCode)
+	}
+	if s.Valid(refreshed.Token) {
+		t.Error("revoked token is still valid")
+	}
+}
+
+func TestServer_authorizeFormPost(t *testing.T) {
+	s := oauthtest.NewServer(oauthtest.Config{})
+	defer s.Close()
+
+	resp, err := http.Get(s.AuthorizeURL() + "?" + url.Values{
+		"client_id":     {"CLIENT-ID"},
+		"redirect_uri":  {"http://127.0.0.1:1234/callback"},
+		"state":         {"STATE"},
+		"response_mode": {"form_post"},
+	}.Encode())
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`action="http://127.0.0.1:1234/callback"`,
+		`name="code" value="ac_`,
+		`name="state" value="STATE"`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body does not contain %s:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cli/oauth/api"
+)
+
+// ErrCallbackTimeout is returned from Flow.Wait when a flow created by a CallbackServer did not
+// receive its web redirect in time.
+var ErrCallbackTimeout = errors.New("timed out waiting for the web redirect")
+
+// CallbackServer receives the web redirects of several concurrent flows on a single local listener,
+// e.g. because only one redirect URI with a fixed port is registered for the app. Each redirect is
+// routed to the pending flow with the matching state parameter. It is safe for concurrent use.
+type CallbackServer struct {
+	// Pages customizes the HTML pages rendered in the browser for all flows. Optional.
+	Pages *Pages
+	// Logger receives debug records for local server events. Optional. Secret values are redacted.
+	Logger *slog.Logger
+
+	listener net.Listener
+	host     string
+
+	mu      sync.Mutex
+	flows   map[string]*localServer
+	srv     *http.Server
+	closing bool
+}
+
+// NewCallbackServer starts listening for web redirects, using the same options as InitFlow.
+func NewCallbackServer(opts ...ListenOption) (*CallbackServer, error) {
+	var cfg listenConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	bound, err := bindLocalServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CallbackServer{
+		listener: bound.listener,
+		host:     bound.host,
+		flows:    make(map[string]*localServer),
+	}, nil
+}
+
+// Port returns the port that the server listens on.
+func (s *CallbackServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// InitFlow creates a new Flow whose web redirect is received by this server. If timeout is positive,
+// Wait fails with ErrCallbackTimeout unless the redirect arrives within that time. The flow must not
+// be started with StartServer; closing it only stops routing redirects to it.
+func (s *CallbackServer) InitFlow(timeout time.Duration) (*Flow, error) {
+	state, err := randomString(20)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &localServer{
+		host:       s.host,
+		state:      state,
+		resultChan: make(chan CodeResponse, 1),
+		outcomes:   make(chan pageOutcome, 1),
+		serveErrs:  make(chan error, 1),
+		pages:      s.Pages,
+		listener:   s.listener,
+		logger:     api.RedactLogger(s.Logger),
+	}
+
+	// timer is guarded by s.mu.
+	var timer *time.Timer
+	server.detach = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		delete(s.flows, state)
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return nil, errors.New("callback server is closed")
+	}
+	s.flows[state] = server
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			server.detach()
+			select {
+			case server.serveErrs <- ErrCallbackTimeout:
+			default:
+			}
+		})
+	}
+	s.mu.Unlock()
+
+	return &Flow{
+		Logger: s.Logger,
+		server: server,
+		state:  state,
+	}, nil
+}
+
+// Serve accepts connections until the server is closed. Errors that stop the server unexpectedly are
+// also reported to the Wait of every pending flow.
+func (s *CallbackServer) Serve() error {
+	s.mu.Lock()
+	if s.srv == nil {
+		s.srv = &http.Server{
+			Handler:           s,
+			ReadHeaderTimeout: readHeaderTimeout,
+		}
+	}
+	srv := s.srv
+	s.mu.Unlock()
+
+	api.RedactLogger(s.Logger).Debug("callback server listening", "addr", s.listener.Addr().String())
+	err := srv.Serve(s.listener)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	err = fmt.Errorf("local server failed: %w", err)
+	for _, flow := range s.flows {
+		select {
+		case flow.serveErrs <- err:
+		default:
+		}
+	}
+	return err
+}
+
+// Close shuts down the server, waiting briefly for responses in progress to complete. Pending flows
+// will not receive their web redirect anymore.
+func (s *CallbackServer) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	srv := s.srv
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var err error
+	if srv == nil {
+		err = s.listener.Close()
+	} else {
+		err = srv.Shutdown(ctx)
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP implements http.Handler by passing each request on to the flow with the matching state.
+func (s *CallbackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if prefix, assets := s.Pages.assetsHandler(); assets != nil && strings.HasPrefix(r.URL.Path, prefix) &&
+		r.Method == http.MethodGet && isLoopbackHost(r.Host) {
+		assets.ServeHTTP(w, r)
+		return
+	}
+
+	state := callbackState(w, r)
+	s.mu.Lock()
+	flow := s.flows[state]
+	s.mu.Unlock()
+	if flow != nil {
+		flow.ServeHTTP(w, r)
+		return
+	}
+
+	api.RedactLogger(s.Logger).Debug("callback server rejected request", "method", r.Method, "path", r.URL.Path)
+	if !isLoopbackHost(r.Host) {
+		w.Header().Add("content-type", "text/html")
+		w.WriteHeader(http.StatusMisdirectedRequest)
+		defaultRejectedHTML(w, "unexpected host")
+		return
+	}
+	reason := "state mismatch"
+	if tmpl := s.Pages.template(pageStateMismatch); tmpl != nil {
+		var appName string
+		if s.Pages != nil {
+			appName = s.Pages.AppName
+		}
+		writePage(w, http.StatusBadRequest, tmpl, newPageData(appName, "", nil, errors.New(reason)), nil)
+		return
+	}
+	w.Header().Add("content-type", "text/html")
+	w.WriteHeader(http.StatusBadRequest)
+	defaultRejectedHTML(w, reason)
+}
+
+// callbackState returns the state parameter of r, taken from the form for POST requests.
+func callbackState(w http.ResponseWriter, r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return r.URL.Query().Get("state")
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCallbackBodySize)
+	if err := r.ParseForm(); err != nil {
+		return ""
+	}
+	return r.PostForm.Get("state")
+}
@@ -0,0 +1,131 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cli/oauth/api"
+)
+
+func startCallbackServer(t *testing.T) (*CallbackServer, string) {
+	t.Helper()
+	s, err := NewCallbackServer()
+	if err != nil {
+		t.Fatalf("NewCallbackServer() error: %v", err)
+	}
+	go func() {
+		_ = s.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	return s, fmt.Sprintf("http://127.0.0.1:%d", s.Port())
+}
+
+func TestCallbackServer_routesByState(t *testing.T) {
+	s, baseURL := startCallbackServer(t)
+
+	hosts := []string{"github.com", "ghe.example.com", "ghe.example.org"}
+	flows := make([]*Flow, len(hosts))
+	for i, host := range hosts {
+		flow, err := s.InitFlow(0)
+		if err != nil {
+			t.Fatalf("InitFlow() error: %v", err)
+		}
+		if _, err := flow.BrowserURL("https://"+host+"/login/oauth/authorize", BrowserParams{
+			ClientID:    "CLIENT-ID",
+			RedirectURI: "http://127.0.0.1/callback",
+		}); err != nil {
+			t.Fatalf("BrowserURL() error: %v", err)
+		}
+		flows[i] = flow
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, len(flows))
+	errs := make([]error, len(flows))
+	for i, flow := range flows {
+		wg.Add(1)
+		go func(i int, flow *Flow) {
+			defer wg.Done()
+			client := &apiClient{
+				stubs: []apiStub{
+					{
+						body:        "access_token=TOKEN-" + hosts[i] + "&token_type=bearer",
+						status:      200,
+						contentType: "application/x-www-form-urlencoded",
+					},
+				},
+			}
+			token, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://"+hosts[i]+"/login/oauth/access_token", WaitOptions{})
+			if err == nil {
+				tokens[i] = token.Token
+			}
+			errs[i] = err
+		}(i, flow)
+	}
+
+	// Complete the flows in reverse order.
+	for i := len(flows) - 1; i >= 0; i-- {
+		status, body := getPage(t, baseURL+"/callback?code=CODE&state="+url.QueryEscape(flows[i].state))
+		if status != 200 || body != "<p>You may now close this page and return to the client app.</p>" {
+			t.Errorf("flow %d: status = %d, body = %q", i, status, body)
+		}
+	}
+	wg.Wait()
+
+	for i := range flows {
+		if errs[i] != nil {
+			t.Errorf("flow %d: Wait() error: %v", i, errs[i])
+		} else if tokens[i] != "TOKEN-"+hosts[i] {
+			t.Errorf("flow %d: token = %q", i, tokens[i])
+		}
+	}
+
+	// Completed flows no longer accept callbacks, but the server keeps serving.
+	if status, _ := getPage(t, baseURL+"/callback?code=CODE&state="+url.QueryEscape(flows[0].state)); status != 400 {
+		t.Errorf("replayed callback: status = %d", status)
+	}
+}
+
+func TestCallbackServer_timeout(t *testing.T) {
+	s, baseURL := startCallbackServer(t)
+
+	flow, err := s.InitFlow(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("InitFlow() error: %v", err)
+	}
+
+	_, err = flow.Wait(context.Background(), api.FromFormPoster(&apiClient{}), "https://github.com/login/oauth/access_token", WaitOptions{})
+	if !errors.Is(err, ErrCallbackTimeout) {
+		t.Fatalf("Wait() error = %v, want ErrCallbackTimeout", err)
+	}
+	if status, _ := getPage(t, baseURL+"/?code=CODE&state="+url.QueryEscape(flow.state)); status != 400 {
+		t.Errorf("late callback: status = %d", status)
+	}
+}
+
+func TestCallbackServer_flowCanNotBeStarted(t *testing.T) {
+	s, _ := startCallbackServer(t)
+
+	flow, err := s.InitFlow(0)
+	if err != nil {
+		t.Fatalf("InitFlow() error: %v", err)
+	}
+	if err := flow.StartServer(nil); err == nil {
+		t.Error("expected an error")
+	}
+	if err := flow.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+
+	_ = s.Close()
+	if _, err := s.InitFlow(0); err == nil {
+		t.Error("expected an error after closing the server")
+	}
+}
@@ -2,84 +2,483 @@ package webapp
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"log/slog"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cli/oauth/api"
 )
 
+// readHeaderTimeout bounds how long the local server waits for request headers, so that idle
+// connections can not hold it open.
+const readHeaderTimeout = 10 * time.Second
+
+// maxCallbackBodySize bounds the size of authorization responses that are POSTed to the local server.
+const maxCallbackBodySize = 64 << 10
+
 // CodeResponse represents the code received by the local server's callback handler.
 type CodeResponse struct {
 	Code  string
 	State string
+	// Error is set if the server redirected with an error instead of a code, e.g. because the user
+	// denied the authorization request.
+	Error *api.Error
 }
 
-// bindLocalServer initializes a LocalServer that will listen on a randomly available TCP port.
-func bindLocalServer() (*localServer, error) {
-	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+// bindLocalServer initializes a LocalServer that will listen on the configured loopback address, or
+// on a randomly available TCP port at 127.0.0.1 by default.
+func bindLocalServer(cfg listenConfig) (*localServer, error) {
+	if cfg.listener != nil {
+		addr, ok := cfg.listener.Addr().(*net.TCPAddr)
+		if !ok {
+			return nil, fmt.Errorf("listener address %s is not a TCP address", cfg.listener.Addr())
+		}
+		if !addr.IP.IsLoopback() {
+			return nil, fmt.Errorf("listener address %s is not a loopback address", addr)
+		}
+		return &localServer{
+			host:       addr.IP.String(),
+			listener:   cfg.listener,
+			resultChan: make(chan CodeResponse, 1),
+			outcomes:   make(chan pageOutcome, 1),
+			serveErrs:  make(chan error, 1),
+		}, nil
+	}
+
+	host := cfg.host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	network, err := loopbackNetwork(host)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := cfg.ports
+	if len(ports) == 0 {
+		ports = []int{0}
+	}
+
+	var listener net.Listener
+	for _, port := range ports {
+		if host == "localhost" {
+			listener, err = listenLocalhost(port)
+		} else {
+			listener, err = net.Listen(network, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
+		if len(ports) > 1 {
+			return nil, fmt.Errorf("could not listen on any of the ports %v: %w", ports, err)
+		}
 		return nil, err
 	}
 
-	return &localServer{
+	server := &localServer{
 		listener:   listener,
 		resultChan: make(chan CodeResponse, 1),
-	}, nil
+		outcomes:   make(chan pageOutcome, 1),
+		serveErrs:  make(chan error, 1),
+	}
+	if cfg.host != "" {
+		server.host = host
+	}
+	return server, nil
+}
+
+// loopbackNetwork returns the network to listen on for host, and rejects hosts that are not a
+// loopback address.
+func loopbackNetwork(host string) (string, error) {
+	if host == "localhost" {
+		return "tcp", nil
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil || !ip.IsLoopback():
+		return "", fmt.Errorf("bind address %q is not a loopback address", host)
+	case ip.To4() != nil:
+		return "tcp4", nil
+	default:
+		return "tcp6", nil
+	}
+}
+
+// localhostAttempts bounds how often listenLocalhost picks another random port because the one it got
+// for 127.0.0.1 is taken on ::1.
+const localhostAttempts = 5
+
+// listenLocalhost listens on port at both 127.0.0.1 and ::1, since a browser may resolve "localhost" in
+// the redirect URI to either of them. It listens on 127.0.0.1 only if the system does not support IPv6.
+func listenLocalhost(port int) (net.Listener, error) {
+	var err error
+	for i := 0; i < localhostAttempts; i++ {
+		var l4, l6 net.Listener
+		l4, err = net.Listen("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		l6, err = net.Listen("tcp6", net.JoinHostPort("::1", strconv.Itoa(l4.Addr().(*net.TCPAddr).Port)))
+		if err == nil {
+			return newDualListener(l4, l6), nil
+		}
+		_ = l4.Close()
+
+		probe, probeErr := net.Listen("tcp6", "[::1]:0")
+		if probeErr != nil {
+			// ::1 is not available at all, so browsers can only reach 127.0.0.1.
+			return net.Listen("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		}
+		_ = probe.Close()
+		if port != 0 {
+			break
+		}
+	}
+	return nil, err
+}
+
+// dualListener accepts connections from several listeners that are bound to the same port. Its Addr is
+// that of the first listener.
+type dualListener struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newDualListener(listeners ...net.Listener) *dualListener {
+	d := &dualListener{
+		listeners: listeners,
+		conns:     make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go d.accept(l)
+	}
+	return d
+}
+
+func (d *dualListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case d.conns <- acceptResult{conn, err}:
+		case <-d.done:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *dualListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-d.conns:
+		return r.conn, r.err
+	case <-d.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (d *dualListener) Close() error {
+	err := net.ErrClosed
+	d.closeOnce.Do(func() {
+		close(d.done)
+		err = nil
+		for _, l := range d.listeners {
+			if cerr := l.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (d *dualListener) Addr() net.Addr {
+	return d.listeners[0].Addr()
 }
 
 type localServer struct {
 	CallbackPath     string
 	WriteSuccessHTML func(w io.Writer)
 
+	// host is the host name to use in the redirect URI, if it was explicitly configured.
+	host string
+	// state is the value that a valid callback must carry in its "state" parameter.
+	state      string
 	resultChan chan (CodeResponse)
-	listener   net.Listener
+	// outcomes receives the result of the token exchange, so that the callback request can render
+	// the matching page. If nil, the callback renders the success page right away.
+	outcomes chan pageOutcome
+	pages    *Pages
+	// authHost is the host name of the authorization server, for display in pages.
+	authHost string
+	listener net.Listener
+	logger   *slog.Logger
+	// serveErrs receives the error that made Serve stop unexpectedly.
+	serveErrs chan error
+	// detach, if set, replaces closing the listener for flows that share it through a CallbackServer.
+	detach func()
+
+	mu      sync.Mutex
+	srv     *http.Server
+	closing bool
 }
 
 func (s *localServer) Port() int {
 	return s.listener.Addr().(*net.TCPAddr).Port
 }
 
+// Close stops accepting new connections, leaving requests in progress to complete.
 func (s *localServer) Close() error {
+	if s.detach != nil {
+		s.detach()
+		return nil
+	}
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
 	return s.listener.Close()
 }
 
+// Shutdown stops the server gracefully: it stops accepting new connections and waits for responses
+// in progress, such as the page rendered for the callback, to complete until ctx is done.
+func (s *localServer) Shutdown(ctx context.Context) error {
+	if s.detach != nil {
+		s.detach()
+		return nil
+	}
+	s.mu.Lock()
+	s.closing = true
+	srv := s.srv
+	s.mu.Unlock()
+
+	var err error
+	if srv == nil {
+		err = s.listener.Close()
+	} else {
+		err = srv.Shutdown(ctx)
+	}
+	// The listener is already closed once a callback was received.
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Serve accepts connections until the server is closed. Errors other than those caused by closing the
+// server are also reported to WaitForCode.
 func (s *localServer) Serve() error {
-	return http.Serve(s.listener, s)
+	s.mu.Lock()
+	if s.srv == nil {
+		s.srv = &http.Server{
+			Handler:           s,
+			ReadHeaderTimeout: readHeaderTimeout,
+		}
+	}
+	srv := s.srv
+	s.mu.Unlock()
+
+	err := srv.Serve(s.listener)
+
+	s.mu.Lock()
+	closing := s.closing
+	s.mu.Unlock()
+	if closing || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	err = fmt.Errorf("local server failed: %w", err)
+	select {
+	case s.serveErrs <- err:
+	default:
+	}
+	return err
 }
 
-func (s *localServer) WaitForCode(ctx context.Context) (CodeResponse, error) {
+// WaitForCode blocks until the server has received a valid callback, or until a response arrives on
+// manual, if not nil.
+func (s *localServer) WaitForCode(ctx context.Context, manual <-chan CodeResponse) (CodeResponse, error) {
 	select {
 	case <-ctx.Done():
+		_ = s.Close()
 		return CodeResponse{}, ctx.Err()
+	case err := <-s.serveErrs:
+		return CodeResponse{}, err
 	case code := <-s.resultChan:
 		return code, nil
+	case code := <-manual:
+		return code, nil
+	}
+}
+
+// Finish reports the final result of the flow to the callback request waiting to render its page.
+func (s *localServer) Finish(scopes []string, err error) {
+	if s.outcomes == nil {
+		return
+	}
+	select {
+	case s.outcomes <- pageOutcome{scopes: scopes, err: err}:
+	default:
 	}
 }
 
-// ServeHTTP implements http.Handler.
+// ServeHTTP implements http.Handler. Requests that are not a valid callback for this flow are
+// rejected without ending the flow, so that the server keeps listening for the real redirect.
 func (s *localServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if s.CallbackPath != "" && r.URL.Path != s.CallbackPath {
-		w.WriteHeader(404)
+	logger := api.RedactLogger(s.logger)
+	if prefix, assets := s.pages.assetsHandler(); assets != nil && strings.HasPrefix(r.URL.Path, prefix) &&
+		r.Method == http.MethodGet && isLoopbackHost(r.Host) {
+		assets.ServeHTTP(w, r)
 		return
 	}
-	defer func() {
-		_ = s.Close()
-	}()
 
-	params := r.URL.Query()
-	s.resultChan <- CodeResponse{
+	params, status, reason := s.validateRequest(w, r)
+	if status != http.StatusOK {
+		logger.Debug("local server rejected request", "method", r.Method, "path", r.URL.Path, "reason", reason)
+		if tmpl := s.pages.template(pageStateMismatch); tmpl != nil && reason == "state mismatch" {
+			s.writePage(w, status, tmpl, nil, errors.New(reason))
+			return
+		}
+		w.Header().Add("content-type", "text/html")
+		w.WriteHeader(status)
+		defaultRejectedHTML(w, reason)
+		return
+	}
+	logger.Debug("local server received callback", "method", r.Method, "url", r.URL.String(), "params", params)
+
+	result := CodeResponse{
 		Code:  params.Get("code"),
 		State: params.Get("state"),
+		Error: api.ErrorFromValues(params),
+	}
+	select {
+	case s.resultChan <- result:
+		_ = s.Close()
+	default:
+		// A valid callback was already received, e.g. before the browser retried or reloaded the page.
+		// Only that request renders the result of the flow.
+		logger.Debug("local server received a repeated callback")
+		w.Header().Add("content-type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		defaultCompletedHTML(w)
+		return
 	}
 
-	w.Header().Add("content-type", "text/html")
-	if s.WriteSuccessHTML != nil {
-		s.WriteSuccessHTML(w)
-	} else {
-		defaultSuccessHTML(w)
+	var outcome pageOutcome
+	if result.Error != nil {
+		outcome.err = result.Error
+	} else if s.outcomes != nil {
+		// Render the page only once the token exchange has completed, so that it reflects the result.
+		select {
+		case outcome = <-s.outcomes:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case outcome.err == nil:
+		s.writePage(w, http.StatusOK, s.pages.template(pageSuccess), outcome.scopes, nil)
+	case errors.Is(outcome.err, api.ErrAccessDenied):
+		s.writePage(w, http.StatusOK, s.pages.template(pageDenied), nil, outcome.err)
+	default:
+		s.writePage(w, http.StatusOK, s.pages.template(pageInternalError), nil, outcome.err)
+	}
+}
+
+func (s *localServer) writePage(w http.ResponseWriter, status int, tmpl *template.Template, scopes []string, err error) {
+	var appName string
+	if s.pages != nil {
+		appName = s.pages.AppName
 	}
+	s.mu.Lock()
+	authHost := s.authHost
+	s.mu.Unlock()
+	writePage(w, status, tmpl, newPageData(appName, authHost, scopes, err), func(w io.Writer) {
+		switch {
+		case err != nil:
+			defaultErrorHTML(w, err)
+		case s.WriteSuccessHTML != nil:
+			s.WriteSuccessHTML(w)
+		default:
+			defaultSuccessHTML(w)
+		}
+	})
 }
 
-func defaultSuccessHTML(w io.Writer) {
-	fmt.Fprintf(w, "<p>You may now close this page and return to the client app.</p>")
+// validateRequest checks that r is the authorization response for this flow and returns its
+// parameters, or the HTTP status to reject it with otherwise. The response is accepted both as a GET
+// redirect and as a POST form, as sent by servers using response_mode=form_post.
+func (s *localServer) validateRequest(w http.ResponseWriter, r *http.Request) (url.Values, int, string) {
+	s.mu.Lock()
+	callbackPath := s.CallbackPath
+	s.mu.Unlock()
+	if callbackPath == "" {
+		callbackPath = "/"
+	}
+	if r.URL.Path != callbackPath {
+		return nil, http.StatusNotFound, "not found"
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		return nil, http.StatusMethodNotAllowed, "method not allowed"
+	}
+	// Rejecting foreign host names prevents DNS rebinding attacks from reaching the server.
+	if !isLoopbackHost(r.Host) {
+		return nil, http.StatusMisdirectedRequest, "unexpected host"
+	}
+
+	params := r.URL.Query()
+	if r.Method == http.MethodPost {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/x-www-form-urlencoded" {
+			return nil, http.StatusUnsupportedMediaType, "unsupported content type"
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxCallbackBodySize)
+		if err := r.ParseForm(); err != nil {
+			return nil, http.StatusBadRequest, "malformed form"
+		}
+		params = r.PostForm
+	}
+
+	state := params.Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(s.state)) != 1 {
+		return nil, http.StatusBadRequest, "state mismatch"
+	}
+	return params, http.StatusOK, ""
+}
+
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
@@ -5,7 +5,11 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/cli/oauth/api"
 )
 
 type fakeListener struct {
@@ -50,6 +54,7 @@ func Test_localServer_ServeHTTP(t *testing.T) {
 	listener := &fakeListener{}
 	s := &localServer{
 		CallbackPath: "/hello",
+		state:        "xy/z",
 		resultChan:   make(chan CodeResponse, 1),
 		listener:     listener,
 	}
@@ -92,3 +97,293 @@ func Test_localServer_ServeHTTP(t *testing.T) {
 		t.Error("expected listener to be closed")
 	}
 }
+
+func Test_localServer_ServeHTTP_error(t *testing.T) {
+	s := &localServer{
+		CallbackPath: "/hello",
+		state:        "xy/z",
+		resultChan:   make(chan CodeResponse, 1),
+		listener:     &fakeListener{},
+	}
+
+	w := &responseWriter{}
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:12345/hello?error=access_denied&error_description=%3Cb%3Edenied%3C%2Fb%3E&state=xy%2Fz", nil)
+	s.ServeHTTP(w, req)
+
+	res := <-s.resultChan
+	if !errors.Is(res.Error, api.ErrAccessDenied) {
+		t.Errorf("got error %v", res.Error)
+	}
+	if res.Code != "" {
+		t.Errorf("got code %q", res.Code)
+	}
+	if got := w.written.String(); got != "<p>Authorization failed: &lt;b&gt;denied&lt;/b&gt; (access_denied)</p><p>You may now close this page and return to the client app.</p>" {
+		t.Errorf("written: %q", got)
+	}
+}
+
+func Test_localServer_ServeHTTP_formPost(t *testing.T) {
+	s := &localServer{
+		CallbackPath: "/hello",
+		state:        "xy/z",
+		resultChan:   make(chan CodeResponse, 1),
+		listener:     &fakeListener{},
+	}
+
+	w := &responseWriter{}
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:12345/hello", strings.NewReader("code=ABC-123&state=xy%2Fz&id_token=IDTOKEN"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.ServeHTTP(w, req)
+
+	res := <-s.resultChan
+	if res.Code != "ABC-123" || res.State != "xy/z" {
+		t.Errorf("got %+v", res)
+	}
+	if w.status != 200 {
+		t.Errorf("status = %d", w.status)
+	}
+	if w.written.String() != "<p>You may now close this page and return to the client app.</p>" {
+		t.Errorf("written: %q", w.written.String())
+	}
+}
+
+func Test_localServer_ServeHTTP_rejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		host        string
+		body        string
+		contentType string
+		status      int
+	}{
+		{
+			name:   "wrong path",
+			method: "GET",
+			url:    "http://127.0.0.1:12345/favicon.ico",
+			status: 404,
+		},
+		{
+			name:   "root path",
+			method: "GET",
+			url:    "http://127.0.0.1:12345/?code=ABC-123&state=xy%2Fz",
+			status: 404,
+		},
+		{
+			name:   "wrong method",
+			method: "PUT",
+			url:    "http://127.0.0.1:12345/hello?code=ABC-123&state=xy%2Fz",
+			status: 405,
+		},
+		{
+			name:   "missing state",
+			method: "GET",
+			url:    "http://127.0.0.1:12345/hello?code=ABC-123",
+			status: 400,
+		},
+		{
+			name:   "forged state",
+			method: "GET",
+			url:    "http://127.0.0.1:12345/hello?code=ABC-123&state=forged",
+			status: 400,
+		},
+		{
+			name:   "foreign host",
+			method: "GET",
+			url:    "http://127.0.0.1:12345/hello?code=ABC-123&state=xy%2Fz",
+			host:   "attacker.example:12345",
+			status: 421,
+		},
+		{
+			name:        "form post with state in query only",
+			method:      "POST",
+			url:         "http://127.0.0.1:12345/hello?state=xy%2Fz",
+			body:        "code=ABC-123",
+			contentType: "application/x-www-form-urlencoded",
+			status:      400,
+		},
+		{
+			name:        "form post with forged state",
+			method:      "POST",
+			url:         "http://127.0.0.1:12345/hello",
+			body:        "code=ABC-123&state=forged",
+			contentType: "application/x-www-form-urlencoded",
+			status:      400,
+		},
+		{
+			name:        "post with unexpected content type",
+			method:      "POST",
+			url:         "http://127.0.0.1:12345/hello",
+			body:        `{"code":"ABC-123","state":"xy/z"}`,
+			contentType: "application/json",
+			status:      415,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := &fakeListener{}
+			s := &localServer{
+				CallbackPath: "/hello",
+				state:        "xy/z",
+				resultChan:   make(chan CodeResponse, 1),
+				listener:     listener,
+			}
+
+			w := &responseWriter{}
+			req, _ := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			s.ServeHTTP(w, req)
+
+			if w.status != tt.status {
+				t.Errorf("status = %d, want %d", w.status, tt.status)
+			}
+			if len(s.resultChan) != 0 {
+				t.Errorf("request was accepted as a callback: %+v", <-s.resultChan)
+			}
+			if listener.closed {
+				t.Error("expected listener to stay open")
+			}
+		})
+	}
+}
+
+func Test_isLoopbackHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"127.0.0.1:8400":   true,
+		"127.0.0.1":        true,
+		"[::1]:8400":       true,
+		"localhost:8400":   true,
+		"LOCALHOST":        true,
+		"192.168.1.1:8400": false,
+		"evil.example":     false,
+		"":                 false,
+	} {
+		if got := isLoopbackHost(host); got != want {
+			t.Errorf("isLoopbackHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func Test_bindLocalServer(t *testing.T) {
+	busy, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = busy.Close()
+	}()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	t.Run("default", func(t *testing.T) {
+		s, err := bindLocalServer(listenConfig{})
+		if err != nil {
+			t.Fatalf("bindLocalServer() error: %v", err)
+		}
+		defer func() {
+			_ = s.Close()
+		}()
+		if ip := s.listener.Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("listening on %v", ip)
+		}
+		if s.host != "" {
+			t.Errorf("host = %q", s.host)
+		}
+	})
+
+	t.Run("candidate ports", func(t *testing.T) {
+		free, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		freePort := free.Addr().(*net.TCPAddr).Port
+		_ = free.Close()
+
+		s, err := bindLocalServer(listenConfig{host: "127.0.0.1", ports: []int{busyPort, freePort}})
+		if err != nil {
+			t.Fatalf("bindLocalServer() error: %v", err)
+		}
+		defer func() {
+			_ = s.Close()
+		}()
+		if s.Port() != freePort {
+			t.Errorf("Port() = %d, want %d", s.Port(), freePort)
+		}
+		if s.host != "127.0.0.1" {
+			t.Errorf("host = %q", s.host)
+		}
+	})
+
+	t.Run("all ports taken", func(t *testing.T) {
+		_, err := bindLocalServer(listenConfig{ports: []int{busyPort}})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("localhost", func(t *testing.T) {
+		s, err := bindLocalServer(listenConfig{host: "localhost"})
+		if err != nil {
+			t.Fatalf("bindLocalServer() error: %v", err)
+		}
+		defer func() {
+			_ = s.Close()
+		}()
+		if s.host != "localhost" {
+			t.Errorf("host = %q", s.host)
+		}
+
+		addrs := []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port()))}
+		if probe, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+			_ = probe.Close()
+			addrs = append(addrs, net.JoinHostPort("::1", strconv.Itoa(s.Port())))
+		}
+		for _, addr := range addrs {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dialing %s: %v", addr, err)
+			}
+			_ = conn.Close()
+			accepted, err := s.listener.Accept()
+			if err != nil {
+				t.Fatalf("Accept() error: %v", err)
+			}
+			_ = accepted.Close()
+		}
+
+		_ = s.Close()
+		if _, err := s.listener.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept() after Close error = %v, want net.ErrClosed", err)
+		}
+	})
+
+	t.Run("non-loopback address", func(t *testing.T) {
+		_, err := bindLocalServer(listenConfig{host: "0.0.0.0"})
+		if err == nil || err.Error() != `bind address "0.0.0.0" is not a loopback address` {
+			t.Fatalf("bindLocalServer() error = %v", err)
+		}
+	})
+
+	t.Run("external listener", func(t *testing.T) {
+		l := &fakeListener{addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 8400}}
+		s, err := bindLocalServer(listenConfig{listener: l})
+		if err != nil {
+			t.Fatalf("bindLocalServer() error: %v", err)
+		}
+		if s.host != "::1" || s.Port() != 8400 {
+			t.Errorf("host = %q, port = %d", s.host, s.Port())
+		}
+	})
+}
+
+func TestWithBindAddress(t *testing.T) {
+	var cfg listenConfig
+	WithBindAddress("[::1]")(&cfg)
+	if cfg.host != "::1" {
+		t.Errorf("host = %q", cfg.host)
+	}
+}
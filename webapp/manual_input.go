@@ -0,0 +1,83 @@
+package webapp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/cli/oauth/api"
+)
+
+// readManualInput reads lines from r until one holds the authorization response for state, which is
+// then sent to results. Other lines are rejected with a message to out, if not nil. Nothing is sent
+// if r is exhausted or ctx is done first.
+func readManualInput(ctx context.Context, r io.Reader, state string, results chan<- CodeResponse, out io.Writer) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+		text := scanner.Text()
+		code, ok := parseManualInput(text, state)
+		switch {
+		case strings.TrimSpace(text) == "":
+			continue
+		case !ok:
+			rejectManualInput(out, "The pasted text is neither an address with an authorization response nor a code.")
+			continue
+		case code.State != state:
+			rejectManualInput(out, "The pasted address belongs to another authorization request.")
+			continue
+		}
+		select {
+		case results <- code:
+		case <-ctx.Done():
+		}
+		return
+	}
+}
+
+func rejectManualInput(out io.Writer, reason string) {
+	if out == nil {
+		return
+	}
+	fmt.Fprintf(out, "%s Paste the address of the page that the browser was redirected to: ", reason)
+}
+
+// parseManualInput parses the text that the user pasted in place of the web redirect: either the
+// full URL that the browser was redirected to, its query string, or just the authorization code.
+// A bare code can not carry the state parameter, so it is attributed to the flow in progress.
+func parseManualInput(text, state string) (CodeResponse, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return CodeResponse{}, false
+	}
+
+	query := text
+	if u, err := url.Parse(text); err == nil && u.Scheme != "" {
+		query = u.RawQuery
+	} else if i := strings.IndexByte(text, '?'); i >= 0 {
+		query = text[i+1:]
+	}
+
+	if strings.Contains(query, "=") {
+		params, err := url.ParseQuery(query)
+		if err != nil || (params.Get("code") == "" && params.Get("error") == "") {
+			return CodeResponse{}, false
+		}
+		return CodeResponse{
+			Code:  params.Get("code"),
+			State: params.Get("state"),
+			Error: api.ErrorFromValues(params),
+		}, true
+	}
+	if query != text {
+		// A URL without an authorization response.
+		return CodeResponse{}, false
+	}
+
+	return CodeResponse{Code: text, State: state}, true
+}
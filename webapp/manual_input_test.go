@@ -0,0 +1,95 @@
+package webapp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cli/oauth/api"
+)
+
+func Test_parseManualInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    CodeResponse
+		wantOK  bool
+		wantErr error
+	}{
+		{
+			name:   "redirect URL",
+			text:   "  http://127.0.0.1:8400/callback?code=ABC-123&state=xy%2Fz \n",
+			want:   CodeResponse{Code: "ABC-123", State: "xy/z"},
+			wantOK: true,
+		},
+		{
+			name:   "query string",
+			text:   "/callback?code=ABC-123&state=other",
+			want:   CodeResponse{Code: "ABC-123", State: "other"},
+			wantOK: true,
+		},
+		{
+			name:   "bare code",
+			text:   "ABC-123",
+			want:   CodeResponse{Code: "ABC-123", State: "xy/z"},
+			wantOK: true,
+		},
+		{
+			name:    "error redirect",
+			text:    "http://127.0.0.1:8400/callback?error=access_denied&state=xy%2Fz",
+			want:    CodeResponse{State: "xy/z"},
+			wantOK:  true,
+			wantErr: api.ErrAccessDenied,
+		},
+		{
+			name: "URL without authorization response",
+			text: "http://127.0.0.1:8400/callback",
+		},
+		{
+			name: "blank",
+			text: "   ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseManualInput(tt.text, "xy/z")
+			if ok != tt.wantOK {
+				t.Fatalf("parseManualInput() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got.Code != tt.want.Code || got.State != tt.want.State {
+				t.Errorf("parseManualInput() = %+v, want %+v", got, tt.want)
+			}
+			if tt.wantErr == nil && got.Error != nil || tt.wantErr != nil && !errors.Is(got.Error, tt.wantErr) {
+				t.Errorf("parseManualInput() error = %v, want %v", got.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_readManualInput(t *testing.T) {
+	results := make(chan CodeResponse, 1)
+	out := &bytes.Buffer{}
+	readManualInput(context.Background(), strings.NewReader("\nhttp://127.0.0.1/callback\nhttp://127.0.0.1/callback?code=ABC-123&state=forged\nABC-123\n"), "xy/z", results, out)
+
+	got := <-results
+	if got.Code != "ABC-123" || got.State != "xy/z" {
+		t.Errorf("got %+v", got)
+	}
+	want := "The pasted text is neither an address with an authorization response nor a code. Paste the address of the page that the browser was redirected to: " +
+		"The pasted address belongs to another authorization request. Paste the address of the page that the browser was redirected to: "
+	if out.String() != want {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func Test_readManualInput_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := make(chan CodeResponse, 1)
+	readManualInput(ctx, strings.NewReader("ABC-123\n"), "xy/z", results, nil)
+	if len(results) != 0 {
+		t.Errorf("got %+v", <-results)
+	}
+}
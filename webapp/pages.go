@@ -0,0 +1,162 @@
+package webapp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/cli/oauth/api"
+)
+
+// defaultAssetsPath is the URL path below which Pages.Assets are served.
+const defaultAssetsPath = "/assets/"
+
+// Pages customizes the HTML pages that the local server renders in the user's web browser. Any
+// template left nil falls back to a simple built-in message.
+type Pages struct {
+	// AppName is the name of the client app, made available to templates as PageData.AppName.
+	AppName string
+
+	// Success is rendered once the access token was granted.
+	Success *template.Template
+	// Denied is rendered when the user or the server denied the authorization request.
+	Denied *template.Template
+	// StateMismatch is rendered for callbacks that do not belong to the flow in progress.
+	StateMismatch *template.Template
+	// InternalError is rendered when the authorization failed for any other reason, such as an
+	// error exchanging the code for a token.
+	InternalError *template.Template
+
+	// Assets holds static files, such as stylesheets and logos, that are served by the local server
+	// so that pages do not depend on external hosts. Optional.
+	Assets fs.FS
+	// AssetsPath is the URL path below which Assets are served. Defaults to "/assets/".
+	AssetsPath string
+}
+
+// PageData is the data passed to the templates of Pages.
+type PageData struct {
+	// AppName is the name of the client app.
+	AppName string
+	// Host is the host name of the authorization server.
+	Host string
+	// Scopes are the scopes granted to the access token. Only set for the Success page.
+	Scopes []string
+
+	// Error describes why the authorization failed. Not set for the Success page.
+	Error error
+	// ErrorCode is the OAuth error code, e.g. "access_denied", if any.
+	ErrorCode string
+	// ErrorDescription is the human-readable description of the error provided by the server, if any.
+	ErrorDescription string
+	// ErrorURI links to a page with more information about the error, if provided by the server.
+	ErrorURI string
+}
+
+func newPageData(appName, host string, scopes []string, err error) PageData {
+	data := PageData{
+		AppName: appName,
+		Host:    host,
+		Scopes:  scopes,
+		Error:   err,
+	}
+	var apiError *api.Error
+	if errors.As(err, &apiError) {
+		data.ErrorCode = apiError.Code
+		data.ErrorDescription = apiError.Description()
+		data.ErrorURI = apiError.ErrorURI
+	}
+	return data
+}
+
+// assetsHandler returns the handler serving p.Assets and the path it is mounted at, or a nil handler
+// if there are no assets.
+func (p *Pages) assetsHandler() (string, http.Handler) {
+	if p == nil || p.Assets == nil {
+		return "", nil
+	}
+	prefix := p.AssetsPath
+	if prefix == "" {
+		prefix = defaultAssetsPath
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix, http.StripPrefix(prefix, http.FileServer(http.FS(p.Assets)))
+}
+
+type pageKind int
+
+const (
+	pageSuccess pageKind = iota
+	pageDenied
+	pageStateMismatch
+	pageInternalError
+)
+
+// template returns the template for the given kind of page, or nil if there is none.
+func (p *Pages) template(kind pageKind) *template.Template {
+	if p == nil {
+		return nil
+	}
+	switch kind {
+	case pageSuccess:
+		return p.Success
+	case pageDenied:
+		return p.Denied
+	case pageStateMismatch:
+		return p.StateMismatch
+	default:
+		return p.InternalError
+	}
+}
+
+// pageOutcome is the final result of a flow that the local server renders for the callback request.
+type pageOutcome struct {
+	scopes []string
+	err    error
+}
+
+// writePage renders tmpl with data, or calls fallback if there is no template. Templates are
+// rendered to a buffer first so that an execution error does not leave a half-written page behind.
+func writePage(w http.ResponseWriter, status int, tmpl *template.Template, data PageData, fallback func(io.Writer)) {
+	w.Header().Add("content-type", "text/html")
+	if tmpl == nil {
+		w.WriteHeader(status)
+		fallback(w)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		defaultErrorHTML(w, fmt.Errorf("rendering page: %w", err))
+		return
+	}
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+func defaultSuccessHTML(w io.Writer) {
+	fmt.Fprintf(w, "<p>You may now close this page and return to the client app.</p>")
+}
+
+func defaultCompletedHTML(w io.Writer) {
+	fmt.Fprintf(w, "<p>This authorization was already completed. You may now close this page and return to the client app.</p>")
+}
+
+func defaultRejectedHTML(w io.Writer, reason string) {
+	fmt.Fprintf(w, "<p>This request was rejected: %s. Please continue in the browser window that the client app opened.</p>",
+		html.EscapeString(reason))
+}
+
+func defaultErrorHTML(w io.Writer, err error) {
+	fmt.Fprintf(w, "<p>Authorization failed: %s</p><p>You may now close this page and return to the client app.</p>",
+		html.EscapeString(err.Error()))
+}
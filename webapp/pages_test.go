@@ -0,0 +1,155 @@
+package webapp
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cli/oauth/api"
+)
+
+func testPages() *Pages {
+	return &Pages{
+		AppName:       "Test App",
+		Success:       template.Must(template.New("success").Parse(`{{.AppName}} on {{.Host}} granted {{range .Scopes}}[{{.}}]{{end}}`)),
+		Denied:        template.Must(template.New("denied").Parse(`denied: {{.ErrorCode}} {{.ErrorDescription}}`)),
+		StateMismatch: template.Must(template.New("mismatch").Parse(`mismatch: {{.Error}}`)),
+		InternalError: template.Must(template.New("internal").Parse(`internal: {{.Error}}`)),
+		Assets: fstest.MapFS{
+			"style.css": {Data: []byte("body { color: red }")},
+		},
+	}
+}
+
+func startTestFlow(t *testing.T, pages *Pages) (*Flow, string) {
+	t.Helper()
+	flow, err := InitFlow()
+	if err != nil {
+		t.Fatalf("InitFlow() error: %v", err)
+	}
+	flow.Pages = pages
+	if _, err := flow.BrowserURL("https://github.com/login/oauth/authorize", BrowserParams{
+		ClientID:    "CLIENT-ID",
+		RedirectURI: "http://127.0.0.1/callback",
+	}); err != nil {
+		t.Fatalf("BrowserURL() error: %v", err)
+	}
+	go func() {
+		_ = flow.StartServer(nil)
+	}()
+	return flow, fmt.Sprintf("http://127.0.0.1:%d", flow.server.Port())
+}
+
+func getPage(t *testing.T, u string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		tokenStub  apiStub
+		wantStatus int
+		wantPage   string
+	}{
+		{
+			name:  "success",
+			query: "code=ABC-123",
+			tokenStub: apiStub{
+				body:        "access_token=ATOKEN&token_type=bearer&scope=repo+gist",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+			wantStatus: 200,
+			wantPage:   "Test App on github.com granted [repo][gist]",
+		},
+		{
+			name:       "denied",
+			query:      "error=access_denied&error_description=Nope",
+			wantStatus: 200,
+			wantPage:   "denied: access_denied Nope",
+		},
+		{
+			name:  "token exchange failure",
+			query: "code=ABC-123",
+			tokenStub: apiStub{
+				body:        "error=bad_verification_code",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+			wantStatus: 200,
+			wantPage:   "internal: bad_verification_code",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, baseURL := startTestFlow(t, testPages())
+			client := &apiClient{stubs: []apiStub{tt.tokenStub}}
+
+			waitErr := make(chan error, 1)
+			go func() {
+				_, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/login/oauth/access_token", WaitOptions{})
+				waitErr <- err
+			}()
+
+			status, body := getPage(t, baseURL+"/callback?"+tt.query+"&state="+url.QueryEscape(flow.state))
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if body != tt.wantPage {
+				t.Errorf("page = %q, want %q", body, tt.wantPage)
+			}
+			if err := <-waitErr; (err == nil) != (tt.name == "success") {
+				t.Errorf("Wait() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPages_stateMismatchAndAssets(t *testing.T) {
+	flow, baseURL := startTestFlow(t, testPages())
+	defer func() {
+		_ = flow.server.Close()
+	}()
+
+	status, body := getPage(t, baseURL+"/assets/style.css")
+	if status != 200 || body != "body { color: red }" {
+		t.Errorf("asset: status = %d, body = %q", status, body)
+	}
+
+	status, body = getPage(t, baseURL+"/callback?code=ABC-123&state=forged")
+	if status != 400 || body != "mismatch: state mismatch" {
+		t.Errorf("mismatch: status = %d, body = %q", status, body)
+	}
+}
+
+func Test_writePage_templateError(t *testing.T) {
+	tmpl := template.Must(template.New("broken").Parse(`{{.Missing}}`))
+	w := &responseWriter{}
+	writePage(w, 200, tmpl, PageData{}, func(io.Writer) {})
+
+	if w.status != 500 {
+		t.Errorf("status = %d", w.status)
+	}
+	if !strings.HasPrefix(w.written.String(), "<p>Authorization failed: rendering page: ") {
+		t.Errorf("written: %q", w.written.String())
+	}
+}
@@ -9,32 +9,87 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cli/oauth/api"
 )
 
-type httpClient interface {
-	PostForm(string, url.Values) (*http.Response, error)
-}
-
 // Flow holds the state for the steps of OAuth Web Application flow.
 type Flow struct {
+	// Logger receives debug records for local server events and the token exchange. Optional. Secret
+	// values are redacted.
+	Logger *slog.Logger
+	// Pages customizes the HTML pages rendered in the browser and serves their static assets. Optional.
+	Pages *Pages
+
 	server   *localServer
 	clientID string
 	state    string
 }
 
-// InitFlow creates a new Flow instance by detecting a locally available port number.
-func InitFlow() (*Flow, error) {
-	server, err := bindLocalServer()
+// shutdownTimeout bounds how long closing the local server waits for responses in progress.
+const shutdownTimeout = 5 * time.Second
+
+// ListenOption configures the local server that receives the web redirect.
+type ListenOption func(*listenConfig)
+
+type listenConfig struct {
+	host     string
+	ports    []int
+	listener net.Listener
+}
+
+// WithBindAddress sets the loopback address that the local server listens on, e.g. "127.0.0.1",
+// "::1" or "localhost". The redirect URI will use this host. For "localhost", the server listens on
+// both 127.0.0.1 and ::1, so that the browser can connect whichever it resolves the name to. Defaults
+// to "127.0.0.1".
+func WithBindAddress(host string) ListenOption {
+	return func(cfg *listenConfig) {
+		cfg.host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+}
+
+// WithPort sets a fixed port for the local server to listen on. This is needed for OAuth apps that
+// register a redirect URI with a specific port.
+func WithPort(port int) ListenOption {
+	return WithPorts(port)
+}
+
+// WithPorts sets candidate ports for the local server, tried in order until one is available.
+func WithPorts(ports ...int) ListenOption {
+	return func(cfg *listenConfig) {
+		cfg.ports = ports
+	}
+}
+
+// WithListener makes the local server accept connections from l instead of opening its own listener.
+// The listener must be bound to a TCP loopback address, and other listen options are ignored.
+func WithListener(l net.Listener) ListenOption {
+	return func(cfg *listenConfig) {
+		cfg.listener = l
+	}
+}
+
+// InitFlow creates a new Flow instance by detecting a locally available port number. Pass options to
+// choose the address that the local server listens on.
+func InitFlow(opts ...ListenOption) (*Flow, error) {
+	var cfg listenConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	server, err := bindLocalServer(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	state, _ := randomString(20)
+	server.state = state
 
 	return &Flow{
 		server: server,
@@ -50,18 +105,36 @@ type BrowserParams struct {
 	Audience    string
 	LoginHandle string
 	AllowSignup bool
+	// OIDC holds the OpenID Connect parameters of the request, such as a login hint. Optional.
+	OIDC api.OIDCParams
+	// ResponseMode asks the server to return the authorization response in a specific way, such as
+	// ResponseModeFormPost. Defaults to the server's behavior, which usually is a GET redirect.
+	ResponseMode string
 }
 
+// ResponseModeFormPost asks the server to return the authorization response as a form that the
+// browser POSTs to the redirect URI. Some servers require it, e.g. when an ID token is requested.
+const ResponseModeFormPost = "form_post"
+
 // BrowserURL appends GET query parameters to baseURL and returns the url that the user should
-// navigate to in their web browser.
-func (flow *Flow) BrowserURL(baseURL string, params BrowserParams) (string, error) {
+// navigate to in their web browser. Editors can set additional, e.g. provider-specific, parameters.
+func (flow *Flow) BrowserURL(baseURL string, params BrowserParams, editors ...api.AuthRequestEditorFn) (string, error) {
 	ru, err := url.Parse(params.RedirectURI)
 	if err != nil {
 		return "", err
 	}
 
-	ru.Host = fmt.Sprintf("%s:%d", ru.Hostname(), flow.server.Port())
+	host := flow.server.host
+	if host == "" {
+		host = ru.Hostname()
+	}
+	ru.Host = net.JoinHostPort(host, strconv.Itoa(flow.server.Port()))
+	flow.server.mu.Lock()
 	flow.server.CallbackPath = ru.Path
+	if bu, err := url.Parse(baseURL); err == nil {
+		flow.server.authHost = bu.Hostname()
+	}
+	flow.server.mu.Unlock()
 	flow.clientID = params.ClientID
 
 	q := url.Values{}
@@ -73,27 +146,50 @@ func (flow *Flow) BrowserURL(baseURL string, params BrowserParams) (string, erro
 	if params.Audience != "" {
 		q.Set("audience", params.Audience)
 	}
+	api.EditParams(q, api.WithOIDCParams(params.OIDC))
+	if params.ResponseMode != "" {
+		q.Set("response_mode", params.ResponseMode)
+	}
 	if params.LoginHandle != "" {
 		q.Set("login", params.LoginHandle)
 	}
 	if !params.AllowSignup {
 		q.Set("allow_signup", "false")
 	}
+	api.EditParams(q, editors...)
 
 	return fmt.Sprintf("%s?%s", baseURL, q.Encode()), nil
 }
 
-// StartServer starts the localhost server and blocks until it has received the web redirect. The
-// writeSuccess function can be used to render a HTML page to the user upon completion.
+// StartServer starts the localhost server and blocks until the server is closed. The writeSuccess
+// function can be used to render a HTML page to the user upon completion, unless a Success template
+// is set in Pages. Errors that stop the server unexpectedly are also returned from Wait, so the result
+// may be ignored when calling StartServer in a goroutine. Flows created by a CallbackServer are served
+// by it and can not be started.
 func (flow *Flow) StartServer(writeSuccess func(io.Writer)) error {
+	if flow.server.detach != nil {
+		return errors.New("the flow is served by a CallbackServer")
+	}
 	flow.server.WriteSuccessHTML = writeSuccess
+	flow.server.pages = flow.Pages
+	flow.server.logger = api.RedactLogger(flow.Logger)
+	flow.server.logger.Debug("local server listening", "addr", flow.server.listener.Addr().String())
 	return flow.server.Serve()
 }
 
+// Close shuts down the local server, waiting briefly for responses in progress to complete. Wait
+// closes the server by itself once the flow has completed, but Close should be deferred to release
+// the port in case the flow is abandoned. It is safe to call Close more than once.
+func (flow *Flow) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return flow.server.Shutdown(ctx)
+}
+
 // AccessToken blocks until the browser flow has completed and returns the access token.
 //
 // Deprecated: use Wait.
-func (flow *Flow) AccessToken(c httpClient, tokenURL, clientSecret string) (*api.AccessToken, error) {
+func (flow *Flow) AccessToken(c api.Doer, tokenURL, clientSecret string) (*api.AccessToken, error) {
 	return flow.Wait(context.Background(), c, tokenURL, WaitOptions{ClientSecret: clientSecret})
 }
 
@@ -101,25 +197,85 @@ func (flow *Flow) AccessToken(c httpClient, tokenURL, clientSecret string) (*api
 type WaitOptions struct {
 	// ClientSecret is the app client secret value.
 	ClientSecret string
+	// RequestOptions customizes the token request, e.g. to set the User-Agent. Optional.
+	RequestOptions api.RequestOptions
+	// RequestEditors set additional form values on the token request. Optional.
+	RequestEditors []api.AuthRequestEditorFn
+	// ManualInput is read for the URL that the browser was redirected to, or just the authorization
+	// code, as pasted by the user. This allows completing the flow when the browser runs on another
+	// machine, e.g. over SSH, and can not reach the local server. Whichever of the local server and
+	// ManualInput receives the authorization response first wins. Optional.
+	//
+	// Wait stops reading ManualInput when it returns. A read in progress can only be interrupted if
+	// ManualInput implements io.Closer, in which case Wait closes it. Otherwise, the line typed next
+	// is still consumed, so ManualInput should be dedicated to the flow.
+	ManualInput io.Reader
+	// ManualOutput receives a message for every pasted line that is rejected, e.g. because it belongs
+	// to another authorization request, asking the user to paste again. Optional.
+	ManualOutput io.Writer
 }
 
-// Wait blocks until the browser flow has completed and returns the access token.
-func (flow *Flow) Wait(ctx context.Context, c httpClient, tokenURL string, opts WaitOptions) (*api.AccessToken, error) {
-	code, err := flow.server.WaitForCode(ctx)
+// Wait blocks until the browser flow has completed and returns the access token. The local server is
+// shut down before Wait returns, once the page for the callback has been rendered.
+func (flow *Flow) Wait(ctx context.Context, c api.Doer, tokenURL string, opts WaitOptions) (*api.AccessToken, error) {
+	defer func() {
+		_ = flow.Close()
+	}()
+	if flow.Logger != nil {
+		c = &api.LoggingClient{Client: c, Logger: flow.Logger}
+	}
+
+	var manual chan CodeResponse
+	if opts.ManualInput != nil {
+		api.RedactLogger(flow.Logger).Debug("also accepting the authorization response from manual input")
+		manual = make(chan CodeResponse, 1)
+		manualCtx, stopReading := context.WithCancel(ctx)
+		defer func() {
+			stopReading()
+			if closer, ok := opts.ManualInput.(io.Closer); ok {
+				_ = closer.Close()
+			}
+		}()
+		go readManualInput(manualCtx, opts.ManualInput, flow.state, manual, opts.ManualOutput)
+	}
+
+	code, err := flow.server.WaitForCode(ctx, manual)
 	if err != nil {
+		flow.server.Finish(nil, err)
 		return nil, err
 	}
+
+	token, err := flow.exchange(ctx, c, tokenURL, opts, code)
+	var scopes []string
+	if token != nil {
+		scopes = strings.Fields(token.Scope)
+	}
+	flow.server.Finish(scopes, err)
+	return token, err
+}
+
+// exchange validates the callback result and exchanges its code for an access token.
+func (flow *Flow) exchange(ctx context.Context, c api.Doer, tokenURL string, opts WaitOptions, code CodeResponse) (*api.AccessToken, error) {
+	logger := api.RedactLogger(flow.Logger)
 	if code.State != flow.state {
+		logger.Debug("callback state mismatch")
 		return nil, errors.New("state mismatch")
 	}
+	if code.Error != nil {
+		logger.Debug("authorization failed", "error", code.Error)
+		return nil, code.Error
+	}
+
+	logger.Debug("exchanging authorization code for access token", "url", tokenURL)
+	values := url.Values{
+		"client_id":     {flow.clientID},
+		"client_secret": {opts.ClientSecret},
+		"code":          {code.Code},
+		"state":         {flow.state},
+	}
+	api.EditParams(values, opts.RequestEditors...)
 
-	resp, err := api.PostForm(c, tokenURL,
-		url.Values{
-			"client_id":     {flow.clientID},
-			"client_secret": {opts.ClientSecret},
-			"code":          {code.Code},
-			"state":         {flow.state},
-		})
+	resp, err := api.PostForm(ctx, c, tokenURL, values, opts.RequestOptions)
 	if err != nil {
 		return nil, err
 	}
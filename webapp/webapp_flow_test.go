@@ -1,13 +1,20 @@
 package webapp
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/cli/oauth/api"
 )
 
 func TestFlow_BrowserURL(t *testing.T) {
@@ -25,6 +32,7 @@ func TestFlow_BrowserURL(t *testing.T) {
 	type args struct {
 		baseURL string
 		params  BrowserParams
+		editors []api.AuthRequestEditorFn
 	}
 	tests := []struct {
 		name    string
@@ -69,6 +77,73 @@ func TestFlow_BrowserURL(t *testing.T) {
 			},
 			want: "https://github.com/authorize?audience=https%3A%2F%2Fapi.github.com&client_id=CLIENT-ID&redirect_uri=http%3A%2F%2F127.0.0.1%3A12345%2Fhello&scope=repo+read%3Aorg&state=xy%2Fz",
 		},
+		{
+			name: "form post response mode",
+			fields: fields{
+				server: server,
+				state:  "xy/z",
+			},
+			args: args{
+				baseURL: "https://github.com/authorize",
+				params: BrowserParams{
+					ClientID:     "CLIENT-ID",
+					RedirectURI:  "http://127.0.0.1/hello",
+					Scopes:       []string{"openid"},
+					AllowSignup:  true,
+					ResponseMode: ResponseModeFormPost,
+				},
+			},
+			want: "https://github.com/authorize?client_id=CLIENT-ID&redirect_uri=http%3A%2F%2F127.0.0.1%3A12345%2Fhello&response_mode=form_post&scope=openid&state=xy%2Fz",
+		},
+		{
+			name: "OpenID Connect parameters and editors",
+			fields: fields{
+				server: server,
+				state:  "xy/z",
+			},
+			args: args{
+				baseURL: "https://github.com/authorize",
+				params: BrowserParams{
+					ClientID:    "CLIENT-ID",
+					RedirectURI: "http://127.0.0.1/hello",
+					Scopes:      []string{"openid"},
+					AllowSignup: true,
+					OIDC: api.OIDCParams{
+						Prompt:    "select_account",
+						LoginHint: "monalisa@example.com",
+						MaxAge:    90 * time.Second,
+						UILocales: []string{"de-CH", "en"},
+						ACRValues: []string{"phr"},
+						Claims:    `{"id_token":{"email":null}}`,
+						Nonce:     "NONCE",
+					},
+				},
+				editors: []api.AuthRequestEditorFn{api.WithParam("hd", "example.com")},
+			},
+			want: "https://github.com/authorize?acr_values=phr&claims=%7B%22id_token%22%3A%7B%22email%22%3Anull%7D%7D&client_id=CLIENT-ID&hd=example.com&login_hint=monalisa%40example.com&max_age=90&nonce=NONCE&prompt=select_account&redirect_uri=http%3A%2F%2F127.0.0.1%3A12345%2Fhello&scope=openid&state=xy%2Fz&ui_locales=de-CH+en",
+		},
+		{
+			name: "configured bind address",
+			fields: fields{
+				server: &localServer{
+					host: "::1",
+					listener: &fakeListener{
+						addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 8400},
+					},
+				},
+				state: "xy/z",
+			},
+			args: args{
+				baseURL: "https://github.com/authorize",
+				params: BrowserParams{
+					ClientID:    "CLIENT-ID",
+					RedirectURI: "http://127.0.0.1/callback",
+					Scopes:      []string{"repo"},
+					AllowSignup: true,
+				},
+			},
+			want: "https://github.com/authorize?client_id=CLIENT-ID&redirect_uri=http%3A%2F%2F%5B%3A%3A1%5D%3A8400%2Fcallback&scope=repo&state=xy%2Fz",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -77,7 +152,7 @@ func TestFlow_BrowserURL(t *testing.T) {
 				clientID: tt.fields.clientID,
 				state:    tt.fields.state,
 			}
-			got, err := flow.BrowserURL(tt.args.baseURL, tt.args.params)
+			got, err := flow.BrowserURL(tt.args.baseURL, tt.args.params, tt.args.editors...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Flow.BrowserURL() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -151,7 +226,7 @@ func TestFlow_AccessToken(t *testing.T) {
 		}
 	}()
 
-	token, err := flow.Wait(context.Background(), client, "https://github.com/access_token", WaitOptions{ClientSecret: "OAUTH-SEKRIT"})
+	token, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{ClientSecret: "OAUTH-SEKRIT"})
 	if err != nil {
 		t.Fatalf("AccessToken() error: %v", err)
 	}
@@ -171,3 +246,355 @@ func TestFlow_AccessToken(t *testing.T) {
 		t.Errorf("Token = %q", token.Token)
 	}
 }
+
+func TestFlow_Wait_authorizationError(t *testing.T) {
+	server := &localServer{
+		listener: &fakeListener{
+			addr: &net.TCPAddr{Port: 12345},
+		},
+		resultChan: make(chan CodeResponse, 1),
+	}
+	flow := Flow{
+		server:   server,
+		clientID: "CLIENT-ID",
+		state:    "xy/z",
+	}
+
+	server.resultChan <- CodeResponse{
+		State: "xy/z",
+		Error: api.ErrorFromValues(url.Values{"error": {"access_denied"}}),
+	}
+
+	client := &apiClient{}
+	_, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{})
+	if !errors.Is(err, api.ErrAccessDenied) {
+		t.Fatalf("Wait() error = %v, want access_denied", err)
+	}
+	var apiError *api.Error
+	if !errors.As(err, &apiError) {
+		t.Errorf("Wait() error is not an *api.Error: %T", err)
+	}
+	if client.postCount != 0 {
+		t.Errorf("expected no HTTP POSTs, got %d", client.postCount)
+	}
+}
+
+func TestFlow_Close(t *testing.T) {
+	flow, err := InitFlow()
+	if err != nil {
+		t.Fatalf("InitFlow() error: %v", err)
+	}
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", flow.server.Port())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- flow.StartServer(nil)
+	}()
+
+	if err := flow.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+	if err := flow.Close(); err != nil {
+		t.Errorf("second Close() error: %v", err)
+	}
+	if _, err := http.Get(baseURL + "/callback"); err == nil {
+		t.Error("expected the server to be closed")
+	}
+
+	// The port is released.
+	l, err := net.Listen("tcp4", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatalf("port was not released: %v", err)
+	}
+	_ = l.Close()
+}
+
+func TestFlow_Wait_serveError(t *testing.T) {
+	flow, err := InitFlow(WithListener(&fakeListener{
+		addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345},
+	}))
+	if err != nil {
+		t.Fatalf("InitFlow() error: %v", err)
+	}
+	go func() {
+		_ = flow.StartServer(nil)
+	}()
+
+	_, err = flow.Wait(context.Background(), api.FromFormPoster(&apiClient{}), "https://github.com/access_token", WaitOptions{})
+	if err == nil || err.Error() != "local server failed: not implemented" {
+		t.Fatalf("Wait() error = %v", err)
+	}
+}
+
+func TestFlow_Wait_shutsDownServer(t *testing.T) {
+	flow, baseURL := startTestFlow(t, nil)
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{})
+		waitErr <- err
+	}()
+
+	status, body := getPage(t, baseURL+"/callback?code=ABC-123&state="+url.QueryEscape(flow.state))
+	if status != 200 || body != "<p>You may now close this page and return to the client app.</p>" {
+		t.Errorf("status = %d, body = %q", status, body)
+	}
+	if err := <-waitErr; err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if _, err := http.Get(baseURL + "/callback"); err == nil {
+		t.Error("expected the server to be shut down")
+	}
+}
+
+func TestFlow_Wait_repeatedCallback(t *testing.T) {
+	flow, baseURL := startTestFlow(t, nil)
+	addr := strings.TrimPrefix(baseURL, "http://")
+	callback := "/callback?code=ABC-123&state=" + url.QueryEscape(flow.state)
+
+	// Both connections are accepted before the first callback makes the server stop listening, as
+	// when the browser reuses a connection to retry or reload the page.
+	type conn struct {
+		net.Conn
+		r *bufio.Reader
+	}
+	dial := func() *conn {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = c.Close()
+		})
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		return &conn{Conn: c, r: bufio.NewReader(c)}
+	}
+	send := func(c *conn, path string) {
+		if _, err := fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", path, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(c *conn) (int, string) {
+		resp, err := http.ReadResponse(c.r, nil)
+		if err != nil {
+			t.Fatalf("reading response: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	first, second := dial(), dial()
+	send(first, "/favicon.ico")
+	read(first)
+	send(second, "/favicon.ico")
+	read(second)
+
+	send(first, callback)
+	for deadline := time.Now().Add(2 * time.Second); len(flow.server.resultChan) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the first callback was not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	send(second, callback)
+	status, body := read(second)
+	if status != 200 || body != "<p>This authorization was already completed. You may now close this page and return to the client app.</p>" {
+		t.Errorf("repeated callback: status = %d, body = %q", status, body)
+	}
+
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+	start := time.Now()
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{})
+		waitErr <- err
+	}()
+
+	status, body = read(first)
+	if status != 200 || body != "<p>You may now close this page and return to the client app.</p>" {
+		t.Errorf("first callback: status = %d, body = %q", status, body)
+	}
+	if err := <-waitErr; err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	// Wait shuts down the server, which must not wait for a handler that is stuck.
+	if elapsed := time.Since(start); elapsed > shutdownTimeout/2 {
+		t.Errorf("Wait() took %v", elapsed)
+	}
+	if client.postCount != 1 {
+		t.Errorf("expected 1 HTTP POST, got %d", client.postCount)
+	}
+}
+
+func TestFlow_Wait_requestEditors(t *testing.T) {
+	server := &localServer{
+		listener: &fakeListener{
+			addr: &net.TCPAddr{Port: 12345},
+		},
+		resultChan: make(chan CodeResponse, 1),
+	}
+	flow := Flow{
+		server:   server,
+		clientID: "CLIENT-ID",
+		state:    "xy/z",
+	}
+	server.resultChan <- CodeResponse{Code: "ABC-123", State: "xy/z"}
+
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+	_, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{
+		RequestEditors: []api.AuthRequestEditorFn{api.WithParam("redirect_uri", "http://127.0.0.1:12345/hello")},
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if got := client.calls[0].params.Get("redirect_uri"); got != "http://127.0.0.1:12345/hello" {
+		t.Errorf("redirect_uri = %q", got)
+	}
+}
+
+func TestFlow_Wait_manualInput(t *testing.T) {
+	server := &localServer{
+		listener: &fakeListener{
+			addr: &net.TCPAddr{Port: 12345},
+		},
+		resultChan: make(chan CodeResponse),
+	}
+	flow := Flow{
+		server:   server,
+		clientID: "CLIENT-ID",
+		state:    "xy/z",
+	}
+
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+
+	token, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{
+		ManualInput: strings.NewReader("http://127.0.0.1:12345/callback?code=ABC-123&state=xy%2Fz\n"),
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "ATOKEN" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if got := client.calls[0].params.Get("code"); got != "ABC-123" {
+		t.Errorf("exchanged code %q", got)
+	}
+}
+
+func TestFlow_Wait_manualInputStateMismatch(t *testing.T) {
+	server := &localServer{
+		listener: &fakeListener{
+			addr: &net.TCPAddr{Port: 12345},
+		},
+		resultChan: make(chan CodeResponse),
+	}
+	flow := Flow{
+		server:   server,
+		clientID: "CLIENT-ID",
+		state:    "xy/z",
+	}
+
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+	out := &bytes.Buffer{}
+	token, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{
+		ManualInput: strings.NewReader("http://127.0.0.1:12345/callback?code=FORGED&state=forged\n" +
+			"http://127.0.0.1:12345/callback?code=ABC-123&state=xy%2Fz\n"),
+		ManualOutput: out,
+	})
+	if err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if token.Token != "ATOKEN" {
+		t.Errorf("Token = %q", token.Token)
+	}
+	if got := client.calls[0].params.Get("code"); got != "ABC-123" {
+		t.Errorf("exchanged code %q", got)
+	}
+	if !strings.Contains(out.String(), "belongs to another authorization request") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestFlow_Wait_manualInputStopsReading(t *testing.T) {
+	server := &localServer{
+		listener: &fakeListener{
+			addr: &net.TCPAddr{Port: 12345},
+		},
+		resultChan: make(chan CodeResponse, 1),
+	}
+	flow := Flow{
+		server:   server,
+		clientID: "CLIENT-ID",
+		state:    "xy/z",
+	}
+	server.resultChan <- CodeResponse{Code: "ABC-123", State: "xy/z"}
+
+	client := &apiClient{
+		stubs: []apiStub{
+			{
+				body:        "access_token=ATOKEN&token_type=bearer",
+				status:      200,
+				contentType: "application/x-www-form-urlencoded",
+			},
+		},
+	}
+	stdin, typed := io.Pipe()
+	if _, err := flow.Wait(context.Background(), api.FromFormPoster(client), "https://github.com/access_token", WaitOptions{
+		ManualInput: stdin,
+	}); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	// The reader was closed, so nothing typed after the flow completed is consumed.
+	if _, err := io.WriteString(typed, "later\n"); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("writing after Wait() error = %v, want io.ErrClosedPipe", err)
+	}
+}